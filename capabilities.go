@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"repliquay/repliquay/pkg/apicall"
+	"repliquay/repliquay/pkg/quay"
+	"slices"
+	"strings"
+)
+
+// policies for the features needed by the organizations and not supported by a host
+const (
+	unsupportedSkip = "skip"
+	unsupportedFail = "fail"
+)
+
+// reportedFeatures are the features printed for every host, the ones repliquay uses
+var reportedFeatures = []string{quay.FeatureTeamSync}
+
+// detectCapabilities reads the capabilities of host and stores them on its
+// connection. The declared version of the host replaces the detected release
+// and disables the features of newer releases. Hosts whose capabilities cannot
+// be read are assumed to support every other feature.
+func detectCapabilities(ctx context.Context, v HostToken, h *apicall.HostConnection, onAction func(quay.Action)) {
+	// apply -dryrun sends no call, the declared release is all that is known
+	if h.Skips(http.MethodGet) {
+		if v.Version != "" {
+			h.Capabilities = quay.ReleaseCapabilities(v.Version)
+		}
+		return
+	}
+	// both endpoints are public, no token is needed
+	client := quay.New(h, "")
+	client.OnAction = onAction
+	caps, err := client.DetectCapabilities(ctx, v.Version)
+	if err != nil && ctx.Err() == nil {
+		if v.Version != "" {
+			log.Printf("Warning: unable to detect the capabilities of host %s, the features of quay %s are assumed supported: %s", v.Host, v.Version, err)
+		} else {
+			log.Printf("Warning: unable to detect the capabilities of host %s, every feature is assumed supported: %s", v.Host, err)
+		}
+	}
+	h.Capabilities = caps
+	if !caps.Detected {
+		return
+	}
+	var features []string
+	for _, f := range reportedFeatures {
+		if caps.Supports(f) {
+			features = append(features, f)
+		}
+	}
+	if len(features) == 0 {
+		features = []string{"none"}
+	}
+	fmt.Printf("Host %s: quay %s, features: %s\n", v.Host, caps.Version, strings.Join(features, ", "))
+}
+
+// requiredFeatures returns feature -> uses of the feature by the organizations.
+// Repository mirroring is not configured by repliquay, mirror: true needs no feature.
+func requiredFeatures(orgs []Organization) map[string][]string {
+	uses := make(map[string][]string)
+	for _, o := range orgs {
+		if ldapSync && len(o.TeamsList) > 0 {
+			var teams []string
+			for _, t := range o.TeamsList {
+				teams = append(teams, t.Name)
+			}
+			uses[quay.FeatureTeamSync] = append(uses[quay.FeatureTeamSync], fmt.Sprintf("ldap sync of teams %s in organization %s", strings.Join(teams, ", "), o.Name))
+		}
+	}
+	return uses
+}
+
+// checkFeatures reports the features the organizations of host need and the
+// host does not support. With the fail policy they are returned as errors,
+// otherwise they are printed and the api calls needing them are skipped.
+func checkFeatures(v HostToken, h *apicall.HostConnection, orgs []Organization) error {
+	var errs []error
+	caps := h.Capabilities
+	uses := requiredFeatures(orgs)
+	for _, f := range slices.Sorted(maps.Keys(uses)) {
+		if caps.Supports(f) {
+			continue
+		}
+		msg := fmt.Sprintf("feature %s unsupported on host %s (quay %s)", f, v.Host, caps.Version)
+		if unsupported == unsupportedFail {
+			errs = append(errs, fmt.Errorf("%s, needed by %s", msg, strings.Join(uses[f], "; ")))
+			continue
+		}
+		fmt.Printf("Warning: %s, skipping %s\n", msg, strings.Join(uses[f], "; "))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"repliquay/repliquay/pkg/apicall"
+	"repliquay/repliquay/pkg/quay"
+)
+
+func TestCheckFeatures(t *testing.T) {
+	saved, savedPolicy := ldapSync, unsupported
+	t.Cleanup(func() { ldapSync, unsupported = saved, savedPolicy })
+
+	teams := []Organization{{Name: "exp", TeamsList: []TeamStruct{{Name: "devs"}, {Name: "ops"}}}}
+	withSync := apicall.Capabilities{Detected: true, Version: "3.10+", Features: map[string]bool{quay.FeatureTeamSync: true}}
+	withoutSync := apicall.Capabilities{Detected: true, Version: "<3.7", Features: map[string]bool{}}
+	tests := []struct {
+		name     string
+		ldapSync bool
+		policy   string
+		orgs     []Organization
+		caps     apicall.Capabilities
+		err      string
+	}{
+		{"supported", true, unsupportedFail, teams, withSync, ""},
+		{"unsupported skipped", true, unsupportedSkip, teams, withoutSync, ""},
+		{"unsupported failed", true, unsupportedFail, teams, withoutSync, "feature TEAM_SYNCING unsupported on host quay.example.com (quay <3.7), needed by ldap sync of teams devs, ops in organization exp"},
+		{"undetected assumed supported", true, unsupportedFail, teams, apicall.Capabilities{}, ""},
+		{"unsupported by the declared release", true, unsupportedFail, teams, apicall.Capabilities{Version: "3.6", Unsupported: map[string]bool{quay.FeatureTeamSync: true}}, "feature TEAM_SYNCING unsupported"},
+		{"not needed without ldap sync", false, unsupportedFail, teams, withoutSync, ""},
+		{"not needed without teams", true, unsupportedFail, []Organization{{Name: "exp"}}, withoutSync, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ldapSync, unsupported = tt.ldapSync, tt.policy
+			h := &apicall.HostConnection{Hostname: "quay.example.com", Capabilities: tt.caps}
+			err := checkFeatures(HostToken{Host: "quay.example.com"}, h, tt.orgs)
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("error = %v, want none", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
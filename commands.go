@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"repliquay/repliquay/internal/metrics"
+	"repliquay/repliquay/internal/secrets"
+	"repliquay/repliquay/internal/vars"
+	"repliquay/repliquay/pkg/apicall"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// exit code of invalid command lines, as returned by the flag package
+const exitUsage = 2
+
+// command line options shared by the commands
+var (
+	quaysfile string
+	repo      []string
+	confFile  string
+)
+
+type command struct {
+	name    string
+	summary string
+	// flags registers the command options
+	flags func(fs *flag.FlagSet)
+	run   func() int
+}
+
+var commands []command
+
+// commands are set by init as they refer to the usage printing them
+func init() {
+	commands = []command{
+		{"apply", "apply the organization files to every quay (default command)", applyFlags, runApply},
+		{"plan", "print the changes apply would make, performing only read api calls", planFlags, runPlan},
+		{"clone", "copy the organizations of the first quay to the others", cloneFlags, runClone},
+		{"export", "write the organizations of a quay as organization files", exportFlags, runExport},
+		{"validate", "check quays and organization files without calling quay", configFlags, runValidate},
+		{"render", "print the organization files with their variables expanded", configFlags, runRender},
+		{"config", "show: print every apply option and where it comes from, convert: print the conf file as YAML", applyFlags, nil},
+	}
+}
+
+// parseCommand selects the command from the first argument. Flags without a
+// command run apply, keeping the command line of previous releases working.
+func parseCommand(args []string) (cmd command) {
+	name := "apply"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		usage(os.Stdout)
+		os.Exit(0)
+	}
+	i := slices.IndexFunc(commands, func(c command) bool { return c.name == name })
+	if i < 0 {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		usage(os.Stderr)
+		os.Exit(exitUsage)
+	}
+	cmd = commands[i]
+	if cmd.name == "config" {
+		if len(args) == 0 || args[0] != "show" && args[0] != "convert" {
+			fmt.Fprintf(os.Stderr, "Usage: repliquay config show|convert [options]\n")
+			os.Exit(exitUsage)
+		}
+		sub := args[0]
+		args = args[1:]
+		fs, err := loadOptions(cmd, args)
+		if sub == "convert" {
+			// the converted options are not checked, e.g. organization files need not exist
+			if err != nil {
+				log.Printf("Warning: %s", err)
+			}
+			err = convertConf(os.Stdout, fs, confFile)
+		} else if err == nil {
+			showOptions(os.Stdout, fs)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
+	cliCommand, cliArgs = cmd, args
+	if _, err := loadOptions(cmd, args); err != nil {
+		log.Fatal(err)
+	}
+	return
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: repliquay [command] [options]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(w, "\nRun repliquay <command> -h for the options of a command.\n")
+}
+
+// configFlags are the options locating and decoding the configuration files
+func configFlags(fs *flag.FlagSet) {
+	repo, valuesFiles = nil, nil
+	fs.Var(listFlag{&repo, checkRepoArg}, "repo", "quay repo file name, directory or glob pattern, can be repeated or comma separated")
+	fs.StringVar(&quaysfile, "quaysfile", "", "quay token file name")
+	fs.StringVar(&confFile, "conf", defaultConfFile, "repliquay config file, its options apply when not set by flags or environment variables")
+	fs.Var(listFlag{&valuesFiles, nil}, "values", "YAML values file for ${VAR} references in quays and organization files, can be repeated (environment variables take precedence)")
+	fs.StringVar(&ageIdentity, "ageidentity", "", "age identity file used to decrypt an encrypted quays file (default $"+secrets.AgeIdentityEnv+")")
+	fs.BoolVar(&debug, "debug", false, "print debug messages (default false)")
+}
+
+// connectionFlags are the options of the api calls
+func connectionFlags(fs *flag.FlagSet) {
+	retryPolicy.StatusCodes, retryPolicy.TransportErrors = nil, nil
+	fs.IntVar(&sleepPeriod, "sleep", 100, "deprecated and ignored: connections over max_connections now wait for a free slot")
+	fs.IntVar(&retries, "retries", 3, "max retries on api call failure")
+	fs.DurationVar(&timeouts.Dial, "dialtimeout", apicall.DefaultDialTimeout, "timeout establishing a connection to quay")
+	fs.DurationVar(&timeouts.TLS, "tlstimeout", apicall.DefaultTLSTimeout, "timeout of the TLS handshake")
+	fs.DurationVar(&timeouts.Response, "responsetimeout", apicall.DefaultResponseTimeout, "timeout waiting for quay response headers")
+	fs.DurationVar(&timeouts.Request, "requesttimeout", apicall.DefaultRequestTimeout, "timeout of a whole api call attempt")
+	fs.DurationVar(&retryPolicy.BaseDelay, "backoff", apicall.DefaultBaseDelay, "initial delay between retries, doubled on every attempt")
+	fs.DurationVar(&retryPolicy.MaxDelay, "maxbackoff", apicall.DefaultMaxDelay, "max delay between retries, calls answered with a longer Retry-After header fail")
+	fs.Func("retrystatus", "comma separated HTTP status codes to retry (default "+joinInts(apicall.DefaultRetryStatusCodes)+")", func(s string) (err error) {
+		retryPolicy.StatusCodes, err = parseInts(s)
+		return
+	})
+	fs.Func("retryerrors", "comma separated transport errors to retry: timeout, connrefused, connreset, eof, dns (default "+strings.Join(apicall.DefaultTransportErrors, ",")+")", func(s string) error {
+		retryPolicy.TransportErrors = []string{}
+		for _, v := range strings.Split(s, ",") {
+			v = strings.TrimSpace(v)
+			switch v {
+			case "":
+			case apicall.ErrTimeout, apicall.ErrConnRefused, apicall.ErrConnReset, apicall.ErrEOF, apicall.ErrDNS:
+				retryPolicy.TransportErrors = append(retryPolicy.TransportErrors, v)
+			default:
+				return fmt.Errorf("unknown transport error %q", v)
+			}
+		}
+		return nil
+	})
+	fs.BoolVar(&insecure, "insecure", false, "disable TLS connection (default false)")
+	fs.BoolVar(&skipVerify, "skipVerify", false, "enable/disable TLS validation")
+	fs.DurationVar(&runTimeout, "timeout", 0, "stop issuing new api calls after this duration (default no timeout)")
+}
+
+// runFlags are the options of the commands writing to quay
+func runFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&ldapSync, "ldapsync", false, "enable ldap sync (default false)")
+	fs.StringVar(&reportFile, "report", "", "write a JSON report of every api call to this file")
+	fs.StringVar(&junitFile, "junit", "", "write a JUnit XML report of every api call to this file")
+	fs.StringVar(&metricsAddr, "metricsaddr", "", "serve Prometheus metrics on this address (e.g. :9090)")
+	fs.StringVar(&metricsFile, "metricsfile", "", "write Prometheus metrics to this node_exporter textfile at the end of the run")
+	unsupported = unsupportedSkip
+	fs.Func("unsupported", "when a host does not support a feature needed by the organizations: skip the api calls needing it or fail before any change (default skip)", func(s string) error {
+		if s != unsupportedSkip && s != unsupportedFail {
+			return fmt.Errorf("expected %s or %s", unsupportedSkip, unsupportedFail)
+		}
+		unsupported = s
+		return nil
+	})
+}
+
+func applyFlags(fs *flag.FlagSet) {
+	configFlags(fs)
+	connectionFlags(fs)
+	runFlags(fs)
+	filterFlags(fs)
+	fs.BoolVar(&dryRun, "dryrun", false, "enable dry run (default false)")
+	fs.BoolVar(&daemon, "daemon", false, "keep running and reconcile every -interval and whenever the configuration files change")
+	fs.DurationVar(&interval, "interval", 10*time.Minute, "daemon mode: time between reconcile runs")
+	fs.DurationVar(&watchInterval, "watchinterval", 10*time.Second, "daemon mode: how often configuration files are checked for changes")
+	fs.StringVar(&healthAddr, "healthaddr", ":8080", "daemon mode: serve /healthz and /readyz on this address")
+	fs.BoolVar(&clone, "clone", false, "deprecated, use repliquay clone: clone first quay configuration to others")
+}
+
+func planFlags(fs *flag.FlagSet) {
+	configFlags(fs)
+	connectionFlags(fs)
+	runFlags(fs)
+	filterFlags(fs)
+}
+
+func cloneFlags(fs *flag.FlagSet) {
+	planFlags(fs)
+	fs.BoolVar(&dryRun, "dryrun", false, "enable dry run (default false)")
+}
+
+// export options
+var (
+	exportHost string
+	exportDir  string
+)
+
+func exportFlags(fs *flag.FlagSet) {
+	configFlags(fs)
+	connectionFlags(fs)
+	fs.StringVar(&exportHost, "host", "", "quay host to export (default the first host of the quays file)")
+	fs.StringVar(&exportDir, "out", "", "write one <organization>.yaml file per organization in this directory (default stdout)")
+}
+
+// signalContext returns the context of a run: the first SIGINT/SIGTERM stops
+// issuing new api calls, a second one kills repliquay
+func signalContext() (context.Context, context.CancelFunc) {
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigCtx.Done()
+		stop()
+	}()
+	return sigCtx, stop
+}
+
+// runOnce performs a single reconcile run
+func runOnce() int {
+	t1 := time.Now()
+	sigCtx, stop := signalContext()
+	defer stop()
+	ctx := sigCtx
+	if runTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, runTimeout)
+		defer cancel()
+	}
+
+	runMetrics := metrics.New()
+	if daemon {
+		// a run is considered stuck once it lasts twice the timeout (or the interval)
+		h := &health{maxRun: 2 * max(runTimeout, interval)}
+		serveHTTP(runMetrics, h)
+		return runDaemon(sigCtx, runMetrics, h)
+	}
+	if metricsAddr != "" {
+		serveHTTP(runMetrics, nil)
+	}
+
+	runMetrics.RunStarted(t1)
+	res, err := reconcile(ctx, quaysfile, repo, func(string) bool { return false }, runMetrics)
+	if err != nil {
+		log.Fatal(err)
+	}
+	writeReports(res, runMetrics)
+	printSummary(res, t1)
+	switch code := res.exitCode(); code {
+	case 0:
+		fmt.Printf("Repliquay: mission completed in %s\n", time.Since(t1))
+	case exitSomeHostsFailed, exitAllHostsFailed:
+		fmt.Printf("Repliquay: %d of %d hosts failed in %s\n", len(res.failedHosts), len(res.hosts), time.Since(t1))
+	}
+	return res.exitCode()
+}
+
+func runApply() int {
+	return runOnce()
+}
+
+func runPlan() int {
+	// the conf file cannot turn a plan into an apply or a clone
+	dryRun, clone = true, false
+	// a plan reads the hosts to print the changes against their current state
+	dryRunReads = true
+	return runOnce()
+}
+
+func runClone() int {
+	clone = true
+	return runOnce()
+}
+
+func runRender() int {
+	if err := renderOrganizations(os.Stdout, repo); err != nil {
+		log.Print(err)
+		return 1
+	}
+	return 0
+}
+
+// runValidate performs the checks done before the first api call of apply
+func runValidate() int {
+	err := validate()
+	if err != nil {
+		log.Print(err)
+		fmt.Println("Repliquay: configuration is not valid")
+		return 1
+	}
+	fmt.Println("Repliquay: configuration is valid")
+	return 0
+}
+
+func validate() error {
+	values, err := vars.Load(valuesFiles...)
+	if err != nil {
+		return err
+	}
+	hosts, err := loadQuays(quaysfile, values)
+	if err != nil {
+		return err
+	}
+	if err := resolveTokens(hosts); err != nil {
+		return fmt.Errorf("error while resolving quay tokens\n%w", err)
+	}
+	var errs []error
+	for _, v := range hosts {
+		h, err := newHostConnection(v)
+		errs = append(errs, err)
+		h.Close()
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("error while configuring quay connections\n%w", err)
+	}
+	set, err := loadOrganizations(repo, values)
+	if err != nil {
+		return err
+	}
+	if err := checkTargets(set.orgs, hosts); err != nil {
+		return fmt.Errorf("error while checking organization targets\n%w", err)
+	}
+	if err := checkOrgTokens(hosts, set.orgs); err != nil {
+		return fmt.Errorf("error while checking organization tokens\n%w", err)
+	}
+	errs = nil
+	for _, v := range hosts {
+		orgs, _, err := set.forHost(v)
+		errs = append(errs, err)
+		if err == nil {
+			fmt.Printf("Host %s: %d organizations\n", v.Host, len(orgs))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("error while applying organization overlays\n%w", err)
+	}
+	return nil
+}
+
+// runExport reads the organizations of a host and writes them as organization files
+func runExport() int {
+	// progress messages go to stderr, stdout only gets the organization files
+	if err := export(os.Stdout, os.Stderr); err != nil {
+		log.Print(err)
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return exitInterrupted
+		}
+		fmt.Fprintln(os.Stderr, "Repliquay: export failed, no organization written")
+		return 1
+	}
+	return 0
+}
+
+// export writes the organizations of the export host on out, or in exportDir,
+// and its progress messages on progress. Nothing is written when an object
+// cannot be read, an incomplete export would drop it once applied.
+func export(out io.Writer, progress io.Writer) error {
+	ctx, stop := signalContext()
+	defer stop()
+	if runTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, runTimeout)
+		defer cancel()
+	}
+
+	values, err := vars.Load(valuesFiles...)
+	if err != nil {
+		return err
+	}
+	hosts, err := loadQuays(quaysfile, values)
+	if err != nil {
+		return err
+	}
+	i := 0
+	if exportHost != "" {
+		i = slices.IndexFunc(hosts, func(h HostToken) bool { return h.Host == exportHost })
+	}
+	if i < 0 || len(hosts) == 0 {
+		return fmt.Errorf("host %s not defined in the quays file", exportHost)
+	}
+	if err := hosts[i].resolveTokens(); err != nil {
+		return fmt.Errorf("error while resolving quay tokens\n%w", err)
+	}
+	h, err := newHostConnection(hosts[i])
+	if err != nil {
+		return err
+	}
+	h.Output = progress
+	defer h.Close()
+	orgs, _, err := cloneOrganizations(ctx, hosts[i], h, progress)
+	if ctx.Err() != nil {
+		return fmt.Errorf("%s: %w", interruptReason(ctx, "export"), ctx.Err())
+	}
+	if err != nil {
+		return err
+	}
+	slices.SortFunc(orgs, func(a, b Organization) int { return strings.Compare(a.Name, b.Name) })
+
+	for _, o := range orgs {
+		var data bytes.Buffer
+		enc := yaml.NewEncoder(&data)
+		enc.SetIndent(2)
+		if err := enc.Encode(o); err != nil {
+			return fmt.Errorf("unable to encode organization %s: %w", o.Name, err)
+		}
+		if exportDir == "" {
+			fmt.Fprintf(out, "---\n%s", data.Bytes())
+			continue
+		}
+		file := filepath.Join(exportDir, o.Name+".yaml")
+		if err := os.WriteFile(file, data.Bytes(), 0o644); err != nil {
+			return fmt.Errorf("unable to write organization %s: %w", o.Name, err)
+		}
+		fmt.Fprintf(progress, "organization %s written to %s\n", o.Name, file)
+	}
+	return nil
+}
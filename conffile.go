@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// conf file formats, detected from the file extension
+const (
+	formatIni  = "ini"
+	formatYAML = "yaml"
+	formatTOML = "toml"
+)
+
+func confFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return formatYAML
+	case ".toml":
+		return formatTOML
+	}
+	return formatIni
+}
+
+var (
+	// host -> settings of the conf file, merged into the hosts of the quays file
+	confHosts map[string]HostSettings
+	// organization overlays of the conf file, applied after the ones of the organization files
+	confOverlays []Organization
+)
+
+// structuredConf is a YAML or TOML conf file. Its sections hold options as
+// the ini sections do, hosts and overlays the settings without an option.
+type structuredConf struct {
+	Quays    map[string]any          `yaml:"quays,omitempty"`
+	Repos    map[string]any          `yaml:"repos,omitempty"`
+	Filters  map[string]any          `yaml:"filters,omitempty"`
+	Output   map[string]any          `yaml:"output,omitempty"`
+	Params   map[string]any          `yaml:"params,omitempty"`
+	Hosts    map[string]HostSettings `yaml:"hosts,omitempty"`
+	Overlays []Organization          `yaml:"overlays,omitempty"`
+}
+
+func (c *structuredConf) sections() map[string]*map[string]any {
+	return map[string]*map[string]any{
+		"quays":   &c.Quays,
+		"repos":   &c.Repos,
+		"filters": &c.Filters,
+		"output":  &c.Output,
+		"params":  &c.Params,
+	}
+}
+
+// readStructuredConf reads the options of a YAML or TOML conf file and sets
+// its host settings and overlays
+func readStructuredConf(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading conf file: %w", err)
+	}
+	if confFormat(path) == formatTOML {
+		// TOML is converted to YAML, so that hosts and overlays keep the
+		// field names of the quays and organization files
+		var doc map[string]any
+		if err := toml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("error while parsing conf file %s: %w", path, err)
+		}
+		if data, err = yaml.Marshal(doc); err != nil {
+			return nil, fmt.Errorf("error while parsing conf file %s: %w", path, err)
+		}
+	}
+	var c structuredConf
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&c); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("error while parsing conf file %s: %w", path, err)
+	}
+
+	conf := make(map[string]string)
+	for section, keys := range c.sections() {
+		for key, v := range *keys {
+			var name string
+			var ok bool
+			if section == "params" {
+				if name, ok = paramOption(key); !ok {
+					log.Printf("Warning: unknown option %s in section %s of %s", key, section, path)
+					continue
+				}
+			} else if name, ok = confKeys[section+"."+key]; !ok {
+				log.Printf("Warning: unknown key %s in section %s of %s", key, section, path)
+				continue
+			}
+			value, err := confValue(v)
+			if err != nil {
+				return nil, fmt.Errorf("error while parsing conf file %s: %s.%s: %w", path, section, key, err)
+			}
+			conf[name] = value
+		}
+	}
+	for _, ov := range c.Overlays {
+		if ov.Name == "" || ov.Overlay == nil {
+			return nil, fmt.Errorf("error while parsing conf file %s: overlays require quay_organization and overlay", path)
+		}
+	}
+	confHosts, confOverlays = c.Hosts, c.Overlays
+	return conf, nil
+}
+
+// confValue formats a value of a structured conf file as an option value,
+// lists are comma separated
+func confValue(v any) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case []any:
+		values := make([]string, len(v))
+		for i, e := range v {
+			switch e.(type) {
+			case []any, map[string]any:
+				return "", errors.New("expected a value or a list of values")
+			}
+			values[i] = fmt.Sprint(e)
+		}
+		return strings.Join(values, ","), nil
+	case map[string]any:
+		return "", errors.New("expected a value or a list of values")
+	}
+	return fmt.Sprint(v), nil
+}
+
+// applyHostSettings merges the host settings of the conf file into the hosts
+// of the quays file, the conf file taking precedence
+func applyHostSettings(hosts []HostToken, settings map[string]HostSettings) error {
+	var errs []error
+	for name, s := range settings {
+		i := slices.IndexFunc(hosts, func(h HostToken) bool { return h.Host == name })
+		if i < 0 {
+			errs = append(errs, fmt.Errorf("conf file sets host %s not defined in the quays file", name))
+			continue
+		}
+		h := &hosts[i].HostSettings
+		if s.MaxConnection > 0 {
+			h.MaxConnection = s.MaxConnection
+		}
+		if s.Version != "" {
+			h.Version = s.Version
+		}
+		if s.Scheme != "" {
+			h.Scheme = s.Scheme
+		}
+		for _, v := range []struct{ conf, target *string }{
+			{&s.TLS.CAFile, &h.TLS.CAFile},
+			{&s.TLS.CertFile, &h.TLS.CertFile},
+			{&s.TLS.KeyFile, &h.TLS.KeyFile},
+			{&s.TLS.ServerName, &h.TLS.ServerName},
+		} {
+			if *v.conf != "" {
+				*v.target = *v.conf
+			}
+		}
+		if s.TLS.SkipVerify != nil {
+			h.TLS.SkipVerify = s.TLS.SkipVerify
+		}
+		for _, t := range []struct{ conf, target *time.Duration }{
+			{&s.Timeouts.Dial, &h.Timeouts.Dial},
+			{&s.Timeouts.TLS, &h.Timeouts.TLS},
+			{&s.Timeouts.Response, &h.Timeouts.Response},
+			{&s.Timeouts.Request, &h.Timeouts.Request},
+		} {
+			if *t.conf > 0 {
+				*t.target = *t.conf
+			}
+		}
+		for k, v := range s.Labels {
+			if h.Labels == nil {
+				h.Labels = make(map[string]string)
+			}
+			h.Labels[k] = v
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// convertConf writes the conf file at path, whatever its format, as a YAML conf file
+func convertConf(w io.Writer, fs *flag.FlagSet, path string) error {
+	conf, err := readConf(path, true)
+	if err != nil {
+		return err
+	}
+	c := structuredConf{Hosts: confHosts, Overlays: confOverlays}
+	// option -> section.key
+	keys := make(map[string]string)
+	for k, name := range confKeys {
+		keys[name] = k
+	}
+	sections := c.sections()
+	for name, value := range conf {
+		section, key := "params", name
+		if k, ok := keys[name]; ok {
+			section, key, _ = strings.Cut(k, ".")
+		}
+		m := sections[section]
+		if *m == nil {
+			*m = make(map[string]any)
+		}
+		(*m)[key] = typedValue(fs.Lookup(name), value)
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return fmt.Errorf("unable to encode conf file %s: %w", path, err)
+	}
+	return enc.Close()
+}
+
+// typedValue converts an option value read from an ini file to the YAML type of the option
+func typedValue(f *flag.Flag, value string) any {
+	if f != nil {
+		if _, ok := f.Value.(listFlag); ok {
+			values := []string{}
+			for _, v := range strings.Split(value, ",") {
+				if v = strings.TrimSpace(v); v != "" {
+					values = append(values, v)
+				}
+			}
+			return values
+		}
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+			if v, err := strconv.ParseBool(value); err == nil {
+				return v
+			}
+		}
+	}
+	if v, err := strconv.Atoi(value); err == nil {
+		return v
+	}
+	return value
+}
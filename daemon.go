@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"repliquay/repliquay/internal/metrics"
+	"slices"
+	"sync"
+	"time"
+)
+
+// hosts failing consecutive runs are skipped for interval * 2^failures, up to maxHostBackoff intervals
+const maxHostBackoff = 8
+
+// health tracks the daemon state for the liveness and readiness probes
+type health struct {
+	mu sync.Mutex
+	// set after the first run applying a valid configuration
+	ready bool
+	// last configuration load or run error
+	lastErr error
+	// start of the run in progress, zero when idle
+	runStart time.Time
+	// a run lasting more than maxRun makes the liveness probe fail
+	maxRun time.Duration
+}
+
+func (h *health) started(t time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.runStart = t
+}
+
+func (h *health) completed(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.runStart = time.Time{}
+	h.lastErr = err
+	if err == nil {
+		h.ready = true
+	}
+}
+
+// liveness fails when a run is stuck
+func (h *health) liveness(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.runStart.IsZero() && h.maxRun > 0 && time.Since(h.runStart) > h.maxRun {
+		http.Error(w, fmt.Sprintf("run in progress since %s", h.runStart.Format(time.RFC3339)), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// readiness fails until a configuration has been applied and while the configuration is invalid
+func (h *health) readiness(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch {
+	case h.lastErr != nil:
+		http.Error(w, h.lastErr.Error(), http.StatusServiceUnavailable)
+	case !h.ready:
+		http.Error(w, "first run not completed", http.StatusServiceUnavailable)
+	default:
+		fmt.Fprintln(w, "ok")
+	}
+}
+
+// serveHTTP serves /metrics on -metricsaddr and, in daemon mode, /healthz and
+// /readyz on -healthaddr. The endpoints share one server when the addresses match.
+func serveHTTP(runMetrics *metrics.Metrics, h *health) {
+	muxes := make(map[string]*http.ServeMux)
+	mux := func(addr string) *http.ServeMux {
+		if muxes[addr] == nil {
+			muxes[addr] = http.NewServeMux()
+		}
+		return muxes[addr]
+	}
+	if metricsAddr != "" {
+		mux(metricsAddr).Handle("/metrics", runMetrics.Handler())
+	}
+	if h != nil && healthAddr != "" {
+		mux(healthAddr).HandleFunc("/healthz", h.liveness)
+		mux(healthAddr).HandleFunc("/readyz", h.readiness)
+	}
+	for addr, m := range muxes {
+		go func() {
+			log.Print("Serving http on ", addr)
+			if err := http.ListenAndServe(addr, m); err != nil {
+				log.Print("Unable to serve http: ", err)
+			}
+		}()
+	}
+}
+
+// hostBackoff counts the consecutive failed runs of a host
+type hostBackoff struct {
+	failures int
+	until    time.Time
+}
+
+// runDaemon reconciles every interval and whenever the configuration files
+// change, until ctx is done. Runs never overlap: changes detected during a run
+// trigger a single new run once it completes.
+func runDaemon(ctx context.Context, runMetrics *metrics.Metrics, h *health) int {
+	backoff := make(map[string]*hostBackoff)
+	skipHost := func(host string) bool {
+		b := backoff[host]
+		return b != nil && time.Now().Before(b.until)
+	}
+
+	log.Printf("Repliquay: daemon mode, reconciling every %s", interval)
+	// the token files are known once a run has read the quays file
+	var files, tokenFiles []string
+	var lastSum, tokenSum string
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	watch := time.NewTicker(watchInterval)
+	defer watch.Stop()
+	for {
+		t1 := time.Now()
+		// options set by flags or environment variables are kept, the conf file is read again
+		optErr := reloadOptions()
+		if optErr == nil && (interval <= 0 || watchInterval <= 0) {
+			optErr = fmt.Errorf("interval %s and watchinterval %s must be positive", interval, watchInterval)
+		}
+		if optErr == nil {
+			ticker.Reset(interval)
+			watch.Reset(watchInterval)
+		}
+		files = slices.Concat([]string{confFile, quaysfile}, repo, valuesFiles)
+		lastSum = checksum(files)
+
+		runCtx, cancel := ctx, context.CancelFunc(func() {})
+		if runTimeout > 0 {
+			runCtx, cancel = context.WithTimeout(ctx, runTimeout)
+		}
+		h.started(t1)
+		runMetrics.RunStarted(t1)
+		res, err := runResult{}, optErr
+		if optErr == nil {
+			res, err = reconcile(runCtx, quaysfile, repo, skipHost, runMetrics)
+		}
+		cancel()
+		tokenFiles = res.tokenFiles
+		tokenSum = checksum(tokenFiles)
+		h.completed(err)
+		if err != nil {
+			log.Printf("Repliquay: invalid configuration, run skipped: %s", err)
+			runMetrics.RunCompleted(false)
+		} else {
+			writeReports(res, runMetrics)
+			printSummary(res, t1)
+			// hosts not reached by an interrupted run keep their backoff
+			if res.interrupted == "" {
+				updateBackoff(backoff, res)
+			}
+		}
+		if ctx.Err() != nil {
+			fmt.Printf("Repliquay: daemon stopped\n")
+			return 0
+		}
+		fmt.Printf("Repliquay: run completed in %s, next run in %s\n", time.Since(t1), interval)
+
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				fmt.Printf("Repliquay: daemon stopped\n")
+				return 0
+			case <-ticker.C:
+				break wait
+			case <-watch.C:
+				if checksum(files) != lastSum || checksum(tokenFiles) != tokenSum {
+					log.Print("Repliquay: configuration changed, reconciling")
+					ticker.Reset(interval)
+					break wait
+				}
+			}
+		}
+	}
+}
+
+// updateBackoff resets the backoff of the hosts reconciled without errors and
+// doubles it for the failed ones
+func updateBackoff(backoff map[string]*hostBackoff, res runResult) {
+	for _, host := range res.hosts {
+		if !res.failedHosts[host] {
+			delete(backoff, host)
+			continue
+		}
+		b := backoff[host]
+		if b == nil {
+			b = &hostBackoff{}
+			backoff[host] = b
+		}
+		b.failures++
+		wait := interval * time.Duration(min(1<<(b.failures-1), maxHostBackoff))
+		b.until = time.Now().Add(wait)
+		log.Printf("Host %s failed %d consecutive runs, skipping it for %s", host, b.failures, wait)
+	}
+}
+
+// checksum hashes the content of files, so that updates of mounted ConfigMaps
+// and Secrets (symlink swaps) are detected as well as in place writes.
+// Directories and glob patterns are expanded, detecting added or removed files.
+func checksum(files []string) string {
+	hash := sha256.New()
+	for _, f := range files {
+		if expanded, err := expandRepoFiles([]string{f}); err == nil && (len(expanded) != 1 || expanded[0] != filepath.Clean(f)) {
+			fmt.Fprintf(hash, "%s\x00%s\x00", f, checksum(expanded))
+			continue
+		}
+		fmt.Fprintf(hash, "%s\x00", f)
+		fd, err := os.Open(f)
+		if err != nil {
+			fmt.Fprintf(hash, "error %s\x00", err)
+			continue
+		}
+		io.Copy(hash, fd)
+		fd.Close()
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"repliquay/repliquay/internal/vars"
+)
+
+func TestWatchedTokenFiles(t *testing.T) {
+	dir := t.TempDir()
+	hostToken := filepath.Join(dir, "host")
+	orgToken := filepath.Join(dir, "devops")
+	for _, f := range []string{hostToken, orgToken} {
+		if err := os.WriteFile(f, []byte("abc\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	quaysfile := writeFile(t, "quays.yaml", `quays:
+  - host: quay.example.com
+    token_file: `+hostToken+`
+    org_tokens:
+      sandbox: plain
+      devops:
+        token_file: `+orgToken+`
+  - host: dr-quay.example.com
+    token: abc
+`)
+	hosts, err := loadQuays(quaysfile, vars.Values{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := quaysTokenFiles(hosts)
+	if want := []string{hostToken, orgToken}; !slices.Equal(files, want) {
+		t.Fatalf("token files = %q, want %q", files, want)
+	}
+
+	sum := checksum(files)
+	if err := os.WriteFile(orgToken, []byte("rotated\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if checksum(files) == sum {
+		t.Error("checksum unchanged after a token rotation")
+	}
+}
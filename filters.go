@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"slices"
+)
+
+// filters restricting a run to some hosts and organizations
+var (
+	onlyHosts  []string
+	hostLabels string
+	onlyOrgs   []string
+)
+
+func filterFlags(fs *flag.FlagSet) {
+	onlyHosts, onlyOrgs = nil, nil
+	fs.Var(listFlag{&onlyHosts, nil}, "hosts", "only apply to these hosts of the quays file, can be repeated or comma separated")
+	fs.StringVar(&hostLabels, "hostlabels", "", "only apply to the hosts whose labels match this selector (key=value[,key=value])")
+	fs.Var(listFlag{&onlyOrgs, nil}, "orgs", "only apply these organizations, can be repeated or comma separated")
+}
+
+// filterHosts removes the hosts excluded by -hosts and -hostlabels
+func filterHosts(hosts []HostToken) ([]HostToken, error) {
+	var errs []error
+	for _, name := range onlyHosts {
+		if !slices.ContainsFunc(hosts, func(h HostToken) bool { return h.Host == name }) {
+			errs = append(errs, fmt.Errorf("host %s of the hosts filter is not defined in the quays file", name))
+		}
+	}
+	var selector map[string]string
+	if hostLabels != "" {
+		var err error
+		if selector, err = parseSelector(hostLabels); err != nil {
+			errs = append(errs, fmt.Errorf("host labels filter: %w", err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(hosts, func(h HostToken) bool {
+		if len(onlyHosts) > 0 && !slices.Contains(onlyHosts, h.Host) || !matchLabels(selector, h.Labels) {
+			log.Printf("Host %s excluded by filters", h.Host)
+			return true
+		}
+		return false
+	}), nil
+}
+
+// filterOrganizations keeps the organizations selected by -orgs
+func filterOrganizations(orgs []Organization) ([]Organization, error) {
+	if len(onlyOrgs) == 0 {
+		return orgs, nil
+	}
+	var errs []error
+	for _, name := range onlyOrgs {
+		if !slices.ContainsFunc(orgs, func(o Organization) bool { return o.Name == name }) {
+			errs = append(errs, fmt.Errorf("organization %s of the organizations filter is not defined", name))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(orgs, func(o Organization) bool { return !slices.Contains(onlyOrgs, o.Name) }), nil
+}
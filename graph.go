@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// task states
+const (
+	taskPending = iota
+	taskDone
+	taskFailed
+	taskSkipped
+	taskInterrupted
+)
+
+// task is a node of the work graph of a host
+type task struct {
+	phase string
+	name  string
+	run   func(ctx context.Context) error
+	deps  []*task
+	// expand runs instead of run for the tasks adding tasks to the graph
+	expand func(ctx context.Context, add addFunc) error
+
+	// tasks added by expand, owned by the worker until the task is done
+	added []*task
+
+	state      int
+	err        error
+	waiting    int
+	dependents []*task
+	// failed task a skipped task depends on
+	cause *task
+}
+
+// graph is the work of a host. Every task is run as soon as all its
+// dependencies succeeded, the tasks depending on a failed one are skipped.
+type graph struct {
+	tasks []*task
+}
+
+// addFunc adds a task run once deps are done, nil deps are ignored
+type addFunc func(phase string, name string, run func(ctx context.Context) error, deps ...*task) *task
+
+func newTask(phase string, name string, run func(ctx context.Context) error, deps []*task) *task {
+	return &task{
+		phase: phase,
+		name:  name,
+		run:   run,
+		deps:  slices.DeleteFunc(deps, func(d *task) bool { return d == nil }),
+	}
+}
+
+// add adds a task run once deps are done, nil deps are ignored
+func (g *graph) add(phase string, name string, run func(ctx context.Context) error, deps ...*task) *task {
+	t := newTask(phase, name, run, deps)
+	g.tasks = append(g.tasks, t)
+	return t
+}
+
+// addExpand adds a task that, once deps are done, adds more tasks to the graph
+// with add. The added tasks can depend on any task of the graph and are run
+// only if expand succeeds.
+func (g *graph) addExpand(phase string, name string, expand func(ctx context.Context, add addFunc) error, deps ...*task) *task {
+	t := g.add(phase, name, nil, deps...)
+	t.expand = expand
+	return t
+}
+
+// runTask runs t on a worker
+func (t *task) runTask(ctx context.Context) error {
+	if t.expand == nil {
+		return t.run(ctx)
+	}
+	return t.expand(ctx, func(phase string, name string, run func(ctx context.Context) error, deps ...*task) *task {
+		a := newTask(phase, name, run, deps)
+		t.added = append(t.added, a)
+		return a
+	})
+}
+
+// execute runs the tasks on a pool of workers goroutines and returns once
+// every task, including the ones added while running, is done, failed or
+// skipped. Once ctx is done no task is started.
+func (g *graph) execute(ctx context.Context, workers int) {
+	if len(g.tasks) == 0 {
+		return
+	}
+	ready := make(chan *task)
+	done := make(chan *task)
+	// tasks whose dependencies are done, waiting for a worker
+	var queue []*task
+	for _, t := range g.tasks {
+		t.waiting = len(t.deps)
+		for _, d := range t.deps {
+			d.dependents = append(d.dependents, t)
+		}
+	}
+	for _, t := range g.tasks {
+		if t.waiting == 0 {
+			queue = append(queue, t)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range ready {
+				if err := ctx.Err(); err != nil {
+					t.state, t.err = taskInterrupted, err
+				} else if t.err = t.runTask(ctx); t.err != nil && ctx.Err() != nil {
+					// calls cut short by the end of the run are not failures of the object
+					t.state = taskInterrupted
+				}
+				done <- t
+			}
+		}()
+	}
+
+	// states are only updated here, workers just run the tasks
+	for pending := len(g.tasks); pending > 0; {
+		var send chan *task
+		var next *task
+		if len(queue) > 0 {
+			send, next = ready, queue[0]
+		}
+		var t *task
+		select {
+		case send <- next:
+			queue = queue[1:]
+			continue
+		case t = <-done:
+		}
+		pending--
+		if t.state == taskInterrupted || t.err != nil {
+			if t.state != taskInterrupted {
+				t.state = taskFailed
+			}
+			pending -= t.skipDependents(t)
+			continue
+		}
+		t.state = taskDone
+		for _, d := range t.dependents {
+			if d.waiting--; d.waiting == 0 && d.state == taskPending {
+				queue = append(queue, d)
+			}
+		}
+		for _, a := range t.added {
+			g.tasks = append(g.tasks, a)
+			pending++
+			if a.schedule() {
+				queue = append(queue, a)
+			} else if a.state == taskSkipped {
+				pending--
+			}
+		}
+		t.added = nil
+	}
+	close(ready)
+	wg.Wait()
+}
+
+// schedule links a task added while the graph runs to its dependencies,
+// reporting whether it can start. A task depending on a task that already
+// failed or was skipped is skipped.
+func (t *task) schedule() bool {
+	for _, d := range t.deps {
+		switch d.state {
+		case taskDone:
+		case taskPending:
+			t.waiting++
+			d.dependents = append(d.dependents, t)
+		default:
+			t.state, t.cause = taskSkipped, d
+			if d.cause != nil {
+				t.cause = d.cause
+			}
+			return false
+		}
+	}
+	return t.waiting == 0
+}
+
+// skipDependents marks the pending tasks depending on t as skipped because of
+// cause, returning their number
+func (t *task) skipDependents(cause *task) (n int) {
+	for _, d := range t.dependents {
+		if d.state != taskPending {
+			continue
+		}
+		d.state, d.cause = taskSkipped, cause
+		n += 1 + d.skipDependents(cause)
+	}
+	return
+}
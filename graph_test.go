@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func ok(context.Context) error { return nil }
+
+func TestGraphDependencies(t *testing.T) {
+	var g graph
+	var mx sync.Mutex
+	var order []string
+	record := func(name string, err error) func(context.Context) error {
+		return func(context.Context) error {
+			mx.Lock()
+			defer mx.Unlock()
+			order = append(order, name)
+			return err
+		}
+	}
+	org := g.add(phaseObjects, "org", record("org", nil))
+	team := g.add(phaseObjects, "team", record("team", errors.New("invalid team")), org)
+	robot := g.add(phaseObjects, "robot", record("robot", nil), org)
+	repo := g.add(phaseObjects, "repo", record("repo", nil), org)
+	teamPerm := g.add(phasePermissions, "team perm", record("team perm", nil), repo, team)
+	robotPerm := g.add(phasePermissions, "robot perm", record("robot perm", nil), repo, robot)
+	teamSync := g.add(phaseObjects, "sync", record("sync", nil), team)
+	g.execute(context.Background(), 2)
+
+	for _, tt := range []struct {
+		task  *task
+		state int
+		cause *task
+	}{
+		{org, taskDone, nil},
+		{team, taskFailed, nil},
+		{robot, taskDone, nil},
+		{repo, taskDone, nil},
+		{teamPerm, taskSkipped, team},
+		{robotPerm, taskDone, nil},
+		{teamSync, taskSkipped, team},
+	} {
+		if tt.task.state != tt.state || tt.task.cause != tt.cause {
+			t.Errorf("%s: state %d cause %v, want state %d cause %v", tt.task.name, tt.task.state, tt.task.cause, tt.state, tt.cause)
+		}
+	}
+	if team.err == nil {
+		t.Error("team: no error")
+	}
+	if len(order) != 5 || order[0] != "org" {
+		t.Errorf("run tasks = %q, want org first and 5 tasks", order)
+	}
+	if i := slices.Index(order, "robot perm"); i < slices.Index(order, "robot") || i < slices.Index(order, "repo") {
+		t.Errorf("robot perm ran before its dependencies: %q", order)
+	}
+}
+
+func TestGraphSkipsTransitiveDependents(t *testing.T) {
+	var g graph
+	org := g.add(phaseObjects, "org", func(context.Context) error { return errors.New("quota exceeded") })
+	repo := g.add(phaseObjects, "repo", ok, org)
+	perm := g.add(phasePermissions, "perm", ok, repo)
+	g.execute(context.Background(), 4)
+	if org.state != taskFailed {
+		t.Errorf("org: state %d, want failed", org.state)
+	}
+	for _, task := range []*task{repo, perm} {
+		if task.state != taskSkipped || task.cause != org {
+			t.Errorf("%s: state %d cause %v, want skipped because of org", task.name, task.state, task.cause)
+		}
+	}
+}
+
+func TestGraphBoundedWorkers(t *testing.T) {
+	const workers = 3
+	var g graph
+	var running, peak atomic.Int32
+	for range 20 {
+		g.add(phaseObjects, "task", func(context.Context) error {
+			n := running.Add(1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(2 * time.Millisecond)
+			running.Add(-1)
+			return nil
+		})
+	}
+	g.execute(context.Background(), workers)
+	if p := peak.Load(); p > workers || p < 2 {
+		t.Errorf("peak concurrent tasks = %d, want 2 to %d", p, workers)
+	}
+	for _, task := range g.tasks {
+		if task.state != taskDone {
+			t.Fatalf("task state %d, want done", task.state)
+		}
+	}
+}
+
+func TestGraphCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var g graph
+	first := g.add(phaseObjects, "first", func(context.Context) error {
+		cancel()
+		return nil
+	})
+	cut := g.add(phaseObjects, "cut", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	later := g.add(phasePermissions, "later", ok, first)
+	done := make(chan struct{})
+	go func() {
+		g.execute(ctx, 2)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("execute did not return once cancelled")
+	}
+	if first.state != taskDone {
+		t.Errorf("first: state %d, want done", first.state)
+	}
+	if cut.state != taskInterrupted {
+		t.Errorf("cut: state %d, want interrupted", cut.state)
+	}
+	if later.state != taskInterrupted && later.state != taskSkipped {
+		t.Errorf("later: state %d, want interrupted or skipped", later.state)
+	}
+}
+
+func TestGraphExpand(t *testing.T) {
+	var g graph
+	org := g.add(phaseObjects, "org", ok)
+	robot := g.add(phaseObjects, "robot", ok, org)
+	team := g.add(phaseObjects, "team", func(context.Context) error { return errors.New("invalid team") }, org)
+	var added []*task
+	rules := g.addExpand(phasePermissions, "rules", func(ctx context.Context, add addFunc) error {
+		added = append(added,
+			add(phasePermissions, "robot perm", ok, org, robot),
+			add(phasePermissions, "team perm", ok, org, team),
+		)
+		return nil
+	}, org)
+	failing := g.addExpand(phasePermissions, "failing rules", func(ctx context.Context, add addFunc) error {
+		add(phasePermissions, "never", ok, org)
+		return errors.New("cannot list")
+	}, org)
+	g.execute(context.Background(), 2)
+
+	if rules.state != taskDone || failing.state != taskFailed {
+		t.Errorf("rules: state %d, failing rules: state %d, want done and failed", rules.state, failing.state)
+	}
+	if len(g.tasks) != 7 {
+		t.Fatalf("graph has %d tasks, want the 5 declared and the 2 added by rules", len(g.tasks))
+	}
+	if added[0].state != taskDone {
+		t.Errorf("robot perm: state %d, want done", added[0].state)
+	}
+	if added[1].state != taskSkipped || added[1].cause != team {
+		t.Errorf("team perm: state %d cause %v, want skipped because of team", added[1].state, added[1].cause)
+	}
+}
+
+func TestEndGraphStatus(t *testing.T) {
+	fail := func(context.Context) error { return errors.New("invalid") }
+	tests := []struct {
+		name    string
+		build   func(g *graph)
+		objects string
+		perms   string
+		host    string
+	}{
+		{"all done", func(g *graph) {
+			o := g.add(phaseObjects, "org", ok)
+			g.add(phasePermissions, "perm", ok, o)
+		}, statusOK, statusOK, statusOK},
+		{"all permissions skipped", func(g *graph) {
+			o := g.add(phaseObjects, "org", fail)
+			g.add(phasePermissions, "perm", ok, o)
+		}, statusFailed, statusSkipped, statusFailed},
+		{"some permissions skipped", func(g *graph) {
+			o := g.add(phaseObjects, "org", ok)
+			team := g.add(phaseObjects, "team", fail, o)
+			g.add(phasePermissions, "perm", ok, o)
+			g.add(phasePermissions, "team perm", ok, o, team)
+		}, statusFailed, statusPartial, statusFailed},
+		{"no permissions", func(g *graph) {
+			g.add(phaseObjects, "org", ok)
+		}, statusOK, statusOK, statusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var g graph
+			tt.build(&g)
+			g.execute(context.Background(), 2)
+			run := newHostRun("quay.example.com")
+			run.startGraph(phaseObjects, phasePermissions)
+			run.endGraph(context.Background(), &g)
+			if got := run.phases[phaseObjects].status; got != tt.objects {
+				t.Errorf("objects = %s, want %s", got, tt.objects)
+			}
+			if got := run.phases[phasePermissions].status; got != tt.perms {
+				t.Errorf("permissions = %s, want %s", got, tt.perms)
+			}
+			if got := run.status(); got != tt.host {
+				t.Errorf("host = %s, want %s", got, tt.host)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"repliquay/repliquay/pkg/quay"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// phases of the pipeline of a host, in execution order
+const (
+	phaseSetup        = "setup"
+	phaseCapabilities = "capabilities"
+	phaseLogin        = "login"
+	phaseObjects      = "objects"
+	phasePermissions  = "permissions"
+)
+
+var phases = []string{phaseSetup, phaseCapabilities, phaseLogin, phaseObjects, phasePermissions}
+
+// status of a phase or of a host
+const (
+	statusOK          = "ok"
+	statusFailed      = "failed"
+	statusSkipped     = "skipped"
+	statusInterrupted = "interrupted"
+	// some tasks of the phase were skipped, none failed
+	statusPartial = "partial"
+)
+
+// exit codes of a run reaching its end
+const (
+	exitSomeHostsFailed = 3
+	exitAllHostsFailed  = 4
+)
+
+// maxTaskErrors is the number of failed tasks listed for every phase of a host
+const maxTaskErrors = 10
+
+type phaseResult struct {
+	calls  int
+	failed int
+	err    error
+	// empty until the phase ends
+	status string
+	// tasks of the phases run by the graph executor
+	tasks, failedTasks, skippedTasks int
+	taskErrors                       []string
+}
+
+// hostRun tracks the phases of a host. Hosts go through their phases
+// independently: an error stops the pipeline of its host only, failed api
+// calls mark their phase failed without stopping it. The phases run by the
+// graph executor are failed by their failed tasks.
+type hostRun struct {
+	host    string
+	mx      sync.Mutex
+	current string
+	phases  map[string]*phaseResult
+	stopped bool
+}
+
+func newHostRun(host string) *hostRun {
+	return &hostRun{host: host, phases: make(map[string]*phaseResult)}
+}
+
+// start begins phase p
+func (r *hostRun) start(p string) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.current = p
+	r.phases[p] = &phaseResult{}
+}
+
+// observe counts an api call in the current phase
+func (r *hostRun) observe(a quay.Action) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if p := r.phases[r.current]; p != nil {
+		p.calls++
+		if a.Outcome == quay.OutcomeFailed {
+			p.failed++
+		}
+	}
+}
+
+// startGraph begins the phases run together by the graph executor
+func (r *hostRun) startGraph(ps ...string) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.current = ""
+	for _, p := range ps {
+		r.phases[p] = &phaseResult{}
+	}
+}
+
+// endGraph completes the phases of the tasks of g
+func (r *hostRun) endGraph(ctx context.Context, g *graph) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	for _, t := range g.tasks {
+		p := r.phases[t.phase]
+		p.tasks++
+		switch t.state {
+		case taskFailed:
+			p.failedTasks++
+			if len(p.taskErrors) < maxTaskErrors {
+				p.taskErrors = append(p.taskErrors, fmt.Sprintf("%s: %s", t.name, t.err))
+			}
+		case taskSkipped, taskInterrupted:
+			p.skippedTasks++
+		}
+	}
+	for _, p := range r.phases {
+		if p.status != "" {
+			continue
+		}
+		switch {
+		case ctx.Err() != nil:
+			p.status = statusInterrupted
+		case p.failedTasks > 0:
+			p.status = statusFailed
+			if n := p.failedTasks - len(p.taskErrors); n > 0 {
+				p.taskErrors = append(p.taskErrors, fmt.Sprintf("... and %d more", n))
+			}
+		case p.skippedTasks > 0 && p.skippedTasks == p.tasks:
+			p.status = statusSkipped
+		case p.skippedTasks > 0:
+			p.status = statusPartial
+		default:
+			p.status = statusOK
+		}
+	}
+}
+
+// end completes the current phase, reporting whether the pipeline of the host continues
+func (r *hostRun) end(ctx context.Context, err error) bool {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	p := r.phases[r.current]
+	switch {
+	case err != nil:
+		p.err, p.status = err, statusFailed
+	case ctx.Err() != nil:
+		p.status = statusInterrupted
+	case p.failed > 0:
+		p.status = statusFailed
+	default:
+		p.status = statusOK
+	}
+	r.stopped = p.status == statusFailed && p.err != nil || p.status == statusInterrupted
+	return !r.stopped
+}
+
+// running reports whether no phase stopped the pipeline of the host
+func (r *hostRun) running() bool {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	return !r.stopped
+}
+
+// status returns the status of the host, failed when any phase failed or
+// skipped some of its tasks
+func (r *hostRun) status() string {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	status := statusOK
+	for _, p := range r.phases {
+		switch p.status {
+		case statusFailed, statusSkipped, statusPartial:
+			return statusFailed
+		case statusInterrupted, "":
+			status = statusInterrupted
+		}
+	}
+	return status
+}
+
+// phaseStatus describes phase p for the summary table
+func (r *hostRun) phaseStatus(p string) string {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	ph, ok := r.phases[p]
+	switch {
+	case !ok && r.stopped:
+		return statusSkipped
+	case !ok:
+		return "-"
+	case ph.status == "":
+		return statusInterrupted
+	case ph.tasks > 0 && ph.status != statusOK:
+		return fmt.Sprintf("%s (%d/%d failed, %d skipped)", ph.status, ph.failedTasks, ph.tasks, ph.skippedTasks)
+	case ph.status == statusFailed && ph.err == nil:
+		return fmt.Sprintf("%s (%d/%d calls)", statusFailed, ph.failed, ph.calls)
+	}
+	return ph.status
+}
+
+// errors returns the errors stopping the pipeline of the host and the failed tasks
+func (r *hostRun) errors() (errs []string) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	for _, p := range phases {
+		ph := r.phases[p]
+		if ph == nil {
+			continue
+		}
+		if ph.err != nil {
+			errs = append(errs, fmt.Sprintf("Host %s %s: %s", r.host, p, strings.ReplaceAll(ph.err.Error(), "\n", "\n  ")))
+		}
+		for _, e := range ph.taskErrors {
+			errs = append(errs, fmt.Sprintf("Host %s %s: %s", r.host, p, e))
+		}
+	}
+	return
+}
+
+// printHostTable prints the status of every host and phase, followed by the
+// errors stopping the hosts and their failed tasks
+func printHostTable(w io.Writer, runs []*hostRun) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOST\t"+strings.ToUpper(strings.Join(phases, "\t"))+"\tRESULT")
+	for _, r := range runs {
+		row := []string{r.host}
+		for _, p := range phases {
+			row = append(row, r.phaseStatus(p))
+		}
+		fmt.Fprintln(tw, strings.Join(append(row, r.status()), "\t"))
+	}
+	tw.Flush()
+	for _, r := range runs {
+		for _, e := range r.errors() {
+			fmt.Fprintln(w, e)
+		}
+	}
+}
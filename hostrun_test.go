@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"repliquay/repliquay/pkg/quay"
+)
+
+// hostScenarios drive a host through its phases, as reconcile does
+var hostScenarios = map[string]func(ctx context.Context, r *hostRun){
+	"ok": func(ctx context.Context, r *hostRun) {
+		for _, p := range []string{phaseSetup, phaseCapabilities, phaseLogin} {
+			r.start(p)
+			r.observe(quay.Action{Outcome: quay.OutcomeRead})
+			r.end(ctx, nil)
+		}
+		var g graph
+		g.add(phaseObjects, "org", ok)
+		g.execute(ctx, 1)
+		r.startGraph(phaseObjects, phasePermissions)
+		r.endGraph(ctx, &g)
+	},
+	// capability detection falling back does not fail the host
+	"capabilities unavailable": func(ctx context.Context, r *hostRun) {
+		r.start(phaseCapabilities)
+		r.observe(quay.Action{Outcome: quay.OutcomeUnavailable})
+		r.end(ctx, nil)
+	},
+	// a failed call fails its phase without stopping the pipeline
+	"failed call": func(ctx context.Context, r *hostRun) {
+		r.start(phaseLogin)
+		r.observe(quay.Action{Outcome: quay.OutcomeRead})
+		r.observe(quay.Action{Outcome: quay.OutcomeFailed})
+		r.end(ctx, nil)
+	},
+	// an error stops the pipeline, the next phases are skipped
+	"failed phase": func(ctx context.Context, r *hostRun) {
+		r.start(phaseSetup)
+		r.end(ctx, nil)
+		r.start(phaseCapabilities)
+		r.end(ctx, errors.New("feature TEAM_SYNCING unsupported"))
+	},
+	"skipped tasks": func(ctx context.Context, r *hostRun) {
+		var g graph
+		org := g.add(phaseObjects, "org", func(context.Context) error { return errors.New("quota exceeded") })
+		g.add(phasePermissions, "perm", ok, org)
+		g.execute(ctx, 1)
+		r.startGraph(phaseObjects, phasePermissions)
+		r.endGraph(ctx, &g)
+	},
+	// phases still running when the run is cancelled
+	"interrupted": func(_ context.Context, r *hostRun) {
+		ctx, cancel := context.WithCancel(context.Background())
+		r.start(phaseSetup)
+		r.end(ctx, nil)
+		r.start(phaseLogin)
+		cancel()
+		r.end(ctx, nil)
+	},
+	"unfinished": func(ctx context.Context, r *hostRun) {
+		r.start(phaseSetup)
+	},
+}
+
+func TestHostRunStatus(t *testing.T) {
+	tests := []struct {
+		scenario string
+		status   string
+		running  bool
+		// expected status of every phase in the summary table
+		phases map[string]string
+	}{
+		{"ok", statusOK, true, map[string]string{phaseSetup: statusOK, phaseLogin: statusOK, phasePermissions: statusOK}},
+		{"capabilities unavailable", statusOK, true, map[string]string{phaseCapabilities: statusOK, phaseLogin: "-"}},
+		{"failed call", statusFailed, true, map[string]string{phaseLogin: "failed (1/2 calls)", phaseObjects: "-"}},
+		{"failed phase", statusFailed, false, map[string]string{phaseSetup: statusOK, phaseCapabilities: statusFailed, phaseLogin: statusSkipped, phaseObjects: statusSkipped}},
+		{"skipped tasks", statusFailed, true, map[string]string{phaseObjects: "failed (1/1 failed, 0 skipped)", phasePermissions: "skipped (0/1 failed, 1 skipped)"}},
+		{"interrupted", statusInterrupted, false, map[string]string{phaseSetup: statusOK, phaseLogin: statusInterrupted, phaseObjects: statusSkipped}},
+		{"unfinished", statusInterrupted, true, map[string]string{phaseSetup: statusInterrupted}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scenario, func(t *testing.T) {
+			r := newHostRun("quay.example.com")
+			hostScenarios[tt.scenario](context.Background(), r)
+			if got := r.status(); got != tt.status {
+				t.Errorf("status = %s, want %s", got, tt.status)
+			}
+			if got := r.running(); got != tt.running {
+				t.Errorf("running = %t, want %t", got, tt.running)
+			}
+			for p, want := range tt.phases {
+				if got := r.phaseStatus(p); got != want {
+					t.Errorf("%s = %q, want %q", p, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRunExitCode(t *testing.T) {
+	tests := []struct {
+		name        string
+		scenarios   []string
+		interrupted string
+		want        int
+	}{
+		{"every host ok", []string{"ok", "capabilities unavailable"}, "", 0},
+		{"some hosts failed", []string{"ok", "failed call"}, "", exitSomeHostsFailed},
+		{"some hosts skipped", []string{"failed phase", "ok", "skipped tasks"}, "", exitSomeHostsFailed},
+		{"all hosts failed", []string{"failed phase", "skipped tasks"}, "", exitAllHostsFailed},
+		{"interrupted", []string{"ok", "interrupted"}, "interrupted by signal during organizations and permissions creation", exitInterrupted},
+		{"interrupted with failed hosts", []string{"failed call", "failed phase"}, "timeout during capabilities and login check", exitInterrupted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := runResult{interrupted: tt.interrupted}
+			for i, s := range tt.scenarios {
+				r := newHostRun(string(rune('a'+i)) + ".example.com")
+				hostScenarios[s](context.Background(), r)
+				res.hosts = append(res.hosts, r.host)
+				res.runs = append(res.runs, r)
+			}
+			res.collectFailedHosts()
+			if got := res.exitCode(); got != tt.want {
+				t.Errorf("exit code = %d, want %d (failed hosts %v)", got, tt.want, res.failedHosts)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+// Package metrics exposes Prometheus metrics about api calls and reconciled
+// objects, either on an HTTP endpoint or as a node_exporter textfile.
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"repliquay/repliquay/pkg/apicall"
+	"repliquay/repliquay/pkg/quay"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "repliquay"
+
+type Metrics struct {
+	Registry *prometheus.Registry
+	calls    *prometheus.CounterVec
+	retries  *prometheus.CounterVec
+	latency  *prometheus.HistogramVec
+	objects  *prometheus.CounterVec
+	runStart prometheus.Gauge
+	runEnd   prometheus.Gauge
+	runOk    prometheus.Gauge
+	// per host gauges registered by AddHost
+	hosts []prometheus.Collector
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		calls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "api_calls_total",
+			Help:      "Requests sent to Quay by host, method and HTTP status code (\"error\" for transport errors).",
+		}, []string{"host", "method", "status"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "api_retries_total",
+			Help:      "Requests sent to Quay retrying a failed attempt.",
+		}, []string{"host", "method"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "api_call_duration_seconds",
+			Help:      "Latency of the requests sent to Quay.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+		}, []string{"host", "method"}),
+		objects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "objects_total",
+			Help:      "Reconciled Quay objects by host, kind and outcome (created, updated, unchanged, failed, planned).",
+		}, []string{"host", "kind", "outcome"}),
+		runStart: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "last_run_start_timestamp_seconds",
+			Help:      "Start time of the last run.",
+		}),
+		runEnd: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "last_run_end_timestamp_seconds",
+			Help:      "End time of the last run.",
+		}),
+		runOk: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "last_run_success",
+			Help:      "1 if the last run completed without failed objects, 0 otherwise.",
+		}),
+	}
+	m.Registry.MustRegister(m.calls, m.retries, m.latency, m.objects, m.runStart, m.runEnd, m.runOk)
+	return m
+}
+
+// AddHost registers the in flight and queued gauges of a host and observes its
+// requests. Adding a host twice returns an error.
+func (m *Metrics) AddHost(hc *apicall.HostConnection) error {
+	labels := prometheus.Labels{"host": hc.Hostname}
+	gauges := []prometheus.Collector{
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "api_calls_in_flight",
+			Help:        "Requests currently sent to Quay and waiting for a response.",
+			ConstLabels: labels,
+		}, func() float64 {
+			inFlight, _, _ := hc.Stats()
+			return float64(inFlight)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "api_calls_queued",
+			Help:        "Api calls waiting for a free connection.",
+			ConstLabels: labels,
+		}, func() float64 {
+			_, queued, _ := hc.Stats()
+			return float64(queued)
+		}),
+	}
+	for _, g := range gauges {
+		if err := m.Registry.Register(g); err != nil {
+			return fmt.Errorf("metrics of host %s: %w", hc.Hostname, err)
+		}
+		m.hosts = append(m.hosts, g)
+	}
+	hc.OnRequest = m.ObserveRequest
+	return nil
+}
+
+// ResetHosts unregisters the gauges of the hosts added so far, so that a new
+// set of host connections can be added when the configuration is reloaded
+func (m *Metrics) ResetHosts() {
+	for _, c := range m.hosts {
+		m.Registry.Unregister(c)
+	}
+	m.hosts = nil
+}
+
+func (m *Metrics) ObserveRequest(r apicall.RequestInfo) {
+	status := strconv.Itoa(r.StatusCode)
+	if r.Err != nil {
+		status = "error"
+	}
+	m.calls.WithLabelValues(r.Host, r.Method, status).Inc()
+	m.latency.WithLabelValues(r.Host, r.Method).Observe(r.Duration.Seconds())
+	if r.Attempt > 1 {
+		m.retries.WithLabelValues(r.Host, r.Method).Inc()
+	}
+}
+
+// ObserveAction counts the objects written by the quay client, reads are ignored
+func (m *Metrics) ObserveAction(a quay.Action) {
+	if a.Outcome == quay.OutcomeRead || a.Outcome == quay.OutcomeNotFound || a.Outcome == quay.OutcomeDenied || a.Outcome == quay.OutcomeUnavailable || a.Kind == "" {
+		return
+	}
+	m.objects.WithLabelValues(a.Host, a.Kind, a.Outcome).Inc()
+}
+
+func (m *Metrics) RunStarted(t time.Time) {
+	m.runStart.Set(float64(t.Unix()))
+}
+
+func (m *Metrics) RunCompleted(success bool) {
+	m.runEnd.Set(float64(time.Now().Unix()))
+	if success {
+		m.runOk.Set(1)
+	} else {
+		m.runOk.Set(0)
+	}
+}
+
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{})
+}
+
+// WriteTextfile atomically writes the metrics for the node_exporter textfile collector
+func (m *Metrics) WriteTextfile(path string) error {
+	return prometheus.WriteToTextfile(path, m.Registry)
+}
@@ -0,0 +1,221 @@
+// Package report collects the actions performed during a run and writes
+// them as a JSON document or a JUnit XML file.
+package report
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"repliquay/repliquay/pkg/quay"
+	"slices"
+	"sync"
+	"time"
+)
+
+type Entry struct {
+	Host       string  `json:"host"`
+	Org        string  `json:"org,omitempty"`
+	Kind       string  `json:"kind"`
+	Name       string  `json:"name,omitempty"`
+	Operation  string  `json:"operation"`
+	Method     string  `json:"method"`
+	Path       string  `json:"path"`
+	StatusCode int     `json:"status_code"`
+	Attempts   int     `json:"attempts"`
+	Duration   float64 `json:"duration_seconds"`
+	Outcome    string  `json:"outcome"`
+	Error      string  `json:"error,omitempty"`
+	// request body of the planned actions
+	Body string `json:"body,omitempty"`
+}
+
+// HostTotals counts the actions of a host by outcome. Planned actions are
+// counted in Actions although no request is sent.
+type HostTotals struct {
+	Actions  int            `json:"actions"`
+	Failed   int            `json:"failed"`
+	Planned  int            `json:"planned"`
+	Attempts int            `json:"attempts"`
+	Outcomes map[string]int `json:"outcomes"`
+}
+
+type Report struct {
+	mx      sync.Mutex
+	Started time.Time
+	DryRun  bool
+	entries []Entry
+}
+
+type jsonReport struct {
+	Started     time.Time             `json:"started"`
+	Duration    float64               `json:"duration_seconds"`
+	DryRun      bool                  `json:"dry_run"`
+	Interrupted string                `json:"interrupted,omitempty"`
+	Hosts       map[string]HostTotals `json:"hosts"`
+	Actions     []Entry               `json:"actions"`
+}
+
+func New(dryRun bool) *Report {
+	return &Report{Started: time.Now(), DryRun: dryRun}
+}
+
+// Add records an action, it can be used as quay.Client.OnAction
+func (r *Report) Add(a quay.Action) {
+	e := Entry{
+		Host:       a.Host,
+		Org:        a.Org,
+		Kind:       a.Kind,
+		Name:       a.Name,
+		Operation:  a.Operation,
+		Method:     a.Method,
+		Path:       a.Path,
+		StatusCode: a.StatusCode,
+		Attempts:   a.Attempts,
+		Duration:   a.Duration.Seconds(),
+		Outcome:    a.Outcome,
+		Body:       a.Body,
+	}
+	if a.Err != nil {
+		e.Error = a.Err.Error()
+	}
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.entries = append(r.entries, e)
+}
+
+// Entries returns the recorded actions sorted by host, org, kind and name
+func (r *Report) Entries() []Entry {
+	r.mx.Lock()
+	entries := slices.Clone(r.entries)
+	r.mx.Unlock()
+	slices.SortStableFunc(entries, func(a, b Entry) int {
+		for _, c := range [][2]string{{a.Host, b.Host}, {a.Org, b.Org}, {a.Kind, b.Kind}, {a.Name, b.Name}} {
+			if c[0] != c[1] {
+				if c[0] < c[1] {
+					return -1
+				}
+				return 1
+			}
+		}
+		return 0
+	})
+	return entries
+}
+
+func (r *Report) Totals() map[string]HostTotals {
+	totals := make(map[string]HostTotals)
+	for _, e := range r.Entries() {
+		t, ok := totals[e.Host]
+		if !ok {
+			t.Outcomes = make(map[string]int)
+		}
+		t.Actions++
+		t.Attempts += e.Attempts
+		t.Outcomes[e.Outcome]++
+		switch e.Outcome {
+		case quay.OutcomeFailed:
+			t.Failed++
+		case quay.OutcomePlanned:
+			t.Planned++
+		}
+		totals[e.Host] = t
+	}
+	return totals
+}
+
+// WriteJSON writes the report to path. interrupted is the reason the run
+// stopped early, empty when it completed.
+func (r *Report) WriteJSON(path string, interrupted string) error {
+	data, err := json.MarshalIndent(jsonReport{
+		Started:     r.Started,
+		Duration:    time.Since(r.Started).Seconds(),
+		DryRun:      r.DryRun,
+		Interrupted: interrupted,
+		Hosts:       r.Totals(),
+		Actions:     r.Entries(),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     float64          `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      float64         `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+// WriteJUnit writes the report to path with a test suite per host and a test case per action
+func (r *Report) WriteJUnit(path string) error {
+	suites := junitTestSuites{Name: "repliquay", Time: time.Since(r.Started).Seconds()}
+	index := make(map[string]int)
+	for _, e := range r.Entries() {
+		i, ok := index[e.Host]
+		if !ok {
+			i = len(suites.Suites)
+			index[e.Host] = i
+			suites.Suites = append(suites.Suites, junitTestSuite{Name: e.Host, Timestamp: r.Started.Format(time.RFC3339)})
+		}
+		s := &suites.Suites[i]
+		tc := junitTestCase{
+			ClassName: e.Host + "." + e.Org + "." + e.Kind,
+			Name:      e.Operation + " " + e.Kind + " " + e.Name,
+			Time:      e.Duration,
+			SystemOut: fmt.Sprintf("%s %s status %d attempts %d outcome %s", e.Method, e.Path, e.StatusCode, e.Attempts, e.Outcome),
+		}
+		switch e.Outcome {
+		case quay.OutcomeFailed:
+			tc.Failure = &junitFailure{Message: e.Error, Type: fmt.Sprintf("status %d", e.StatusCode), Text: tc.SystemOut}
+			s.Failures++
+			suites.Failures++
+		case quay.OutcomePlanned:
+			tc.Skipped = &junitSkipped{Message: "dry run"}
+			s.Skipped++
+			suites.Skipped++
+		}
+		s.Tests++
+		s.Time += e.Duration
+		suites.Tests++
+		s.Cases = append(s.Cases, tc)
+	}
+	data, err := xml.MarshalIndent(suites, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append([]byte(xml.Header), append(data, '\n')...), 0644)
+}
@@ -0,0 +1,142 @@
+// Package secrets resolves Quay API tokens from their configured source and
+// decrypts age or SOPS encrypted configuration files.
+package secrets
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	ageHeader        = "age-encryption.org/v1"
+	ageArmorHeader   = "-----BEGIN AGE ENCRYPTED FILE-----"
+	AgeIdentityEnv   = "SOPS_AGE_KEY_FILE"
+	sopsBinary       = "sops"
+	sopsMetadataKey  = "sops"
+	sopsMetadataHash = "mac"
+)
+
+// ResolveToken returns the token from exactly one of an inline value, an
+// environment variable name or a file path
+func ResolveToken(token string, tokenEnv string, tokenFile string) (string, error) {
+	sources := 0
+	for _, s := range []string{token, tokenEnv, tokenFile} {
+		if s != "" {
+			sources++
+		}
+	}
+	switch {
+	case sources == 0:
+		return "", errors.New("no token, token_env or token_file defined")
+	case sources > 1:
+		return "", errors.New("only one of token, token_env and token_file can be defined")
+	case tokenEnv != "":
+		v, ok := os.LookupEnv(tokenEnv)
+		if !ok {
+			return "", fmt.Errorf("token_env %s is not set", tokenEnv)
+		}
+		if strings.TrimSpace(v) == "" {
+			return "", fmt.Errorf("token_env %s is empty", tokenEnv)
+		}
+		return strings.TrimSpace(v), nil
+	case tokenFile != "":
+		data, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return "", fmt.Errorf("unable to read token_file: %w", err)
+		}
+		if strings.TrimSpace(string(data)) == "" {
+			return "", fmt.Errorf("token_file %s is empty", tokenFile)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	return token, nil
+}
+
+// ReadFile reads a configuration file, transparently decrypting it when it
+// is age encrypted (binary or armored) or a SOPS encrypted YAML document.
+// ageIdentity is the age identity file, falling back to $SOPS_AGE_KEY_FILE.
+func ReadFile(path string, ageIdentity string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case isAge(data):
+		return decryptAge(path, data, ageIdentity)
+	case isSops(data):
+		return decryptSops(path, ageIdentity)
+	}
+	return data, nil
+}
+
+func isAge(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(ageHeader)) || bytes.HasPrefix(bytes.TrimSpace(data), []byte(ageArmorHeader))
+}
+
+func isSops(data []byte) bool {
+	var doc map[string]any
+	if yaml.Unmarshal(data, &doc) != nil {
+		return false
+	}
+	metadata, ok := doc[sopsMetadataKey].(map[string]any)
+	if !ok {
+		return false
+	}
+	_, ok = metadata[sopsMetadataHash]
+	return ok
+}
+
+func decryptAge(path string, data []byte, identityFile string) ([]byte, error) {
+	if identityFile == "" {
+		identityFile = os.Getenv(AgeIdentityEnv)
+	}
+	if identityFile == "" {
+		return nil, fmt.Errorf("%s is age encrypted but no identity file provided (-ageidentity or $%s)", path, AgeIdentityEnv)
+	}
+	f, err := os.Open(identityFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open age identity file: %w", err)
+	}
+	defer f.Close()
+	identities, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse age identity file %s: %w", identityFile, err)
+	}
+
+	var in io.Reader = bytes.NewReader(data)
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(ageArmorHeader)) {
+		in = armor.NewReader(bufio.NewReader(bytes.NewReader(bytes.TrimSpace(data))))
+	}
+	r, err := age.Decrypt(in, identities...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt %s: %w", path, err)
+	}
+	return io.ReadAll(r)
+}
+
+func decryptSops(path string, ageIdentity string) ([]byte, error) {
+	if _, err := exec.LookPath(sopsBinary); err != nil {
+		return nil, fmt.Errorf("%s is SOPS encrypted but %s binary was not found in PATH", path, sopsBinary)
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(sopsBinary, "--decrypt", path)
+	cmd.Stderr = &stderr
+	if ageIdentity != "" {
+		cmd.Env = append(os.Environ(), AgeIdentityEnv+"="+ageIdentity)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt %s with sops: %w: %s", path, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
@@ -0,0 +1,160 @@
+// Package vars expands ${VAR} references in configuration files with values
+// taken from the environment or from values files.
+package vars
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// reference matches $${...} (escaped) and ${NAME} or ${NAME:-default}
+var reference = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_.]*)(:-([^}]*))?\}`)
+
+// Values are the variables read from values files. Environment variables
+// override them.
+type Values map[string]string
+
+// Load reads YAML values files, later files override earlier ones.
+// Nested mappings are flattened with dots (bu: {name: x} is ${bu.name}).
+func Load(files ...string) (Values, error) {
+	values := make(Values)
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read values file: %w", err)
+		}
+		var doc map[string]any
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("unable to parse values file %s: %w", f, err)
+		}
+		if err := flatten(values, "", doc); err != nil {
+			return nil, fmt.Errorf("values file %s: %w", f, err)
+		}
+	}
+	return values, nil
+}
+
+func flatten(values Values, prefix string, doc map[string]any) error {
+	for k, v := range doc {
+		name := prefix + k
+		switch v := v.(type) {
+		case map[string]any:
+			if err := flatten(values, name+".", v); err != nil {
+				return err
+			}
+		case []any:
+			return fmt.Errorf("%s: lists are not supported", name)
+		case nil:
+			values[name] = ""
+		case string:
+			values[name] = v
+		case bool:
+			values[name] = strconv.FormatBool(v)
+		default:
+			values[name] = fmt.Sprint(v)
+		}
+	}
+	return nil
+}
+
+// Lookup returns the environment variable name, falling back to the values files
+func (v Values) Lookup(name string) (string, bool) {
+	if s, ok := os.LookupEnv(name); ok {
+		return s, true
+	}
+	s, ok := v[name]
+	return s, ok
+}
+
+// Expand replaces the ${NAME} references in the keys and values of the YAML
+// documents of data, ${NAME:-default} uses default when NAME is not defined
+// and $${ is a literal ${. Comments are left untouched and substituted values
+// are never parsed as YAML: the documents are re-encoded, quoting the values
+// that need it. Every undefined variable is reported in the error.
+func (v Values) Expand(file string, data []byte) ([]byte, error) {
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	var undefined []string
+	encoded := 0
+	for {
+		var doc yaml.Node
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		v.expandNode(&doc, &undefined)
+		if len(doc.Content) == 0 {
+			continue
+		}
+		if err := enc.Encode(&doc); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		encoded++
+	}
+	if len(undefined) > 0 {
+		return nil, fmt.Errorf("%s: undefined variables %s", file, strings.Join(undefined, ", "))
+	}
+	// the encoder cannot close an empty stream
+	if encoded == 0 {
+		return nil, nil
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return out.Bytes(), nil
+}
+
+// expandNode expands the scalars of n and its children, adding the undefined
+// variables to undefined
+func (v Values) expandNode(n *yaml.Node, undefined *[]string) {
+	for _, c := range n.Content {
+		v.expandNode(c, undefined)
+	}
+	if n.Kind != yaml.ScalarNode {
+		return
+	}
+	value := v.expandString(n.Value, undefined)
+	if value == n.Value {
+		return
+	}
+	n.Value = value
+	// the tag of plain values is resolved again from the substituted value,
+	// e.g. ${PORT} becomes an integer
+	if n.Style&(yaml.TaggedStyle|yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle|yaml.LiteralStyle|yaml.FoldedStyle) == 0 {
+		n.Tag = ""
+	}
+}
+
+func (v Values) expandString(s string, undefined *[]string) string {
+	return reference.ReplaceAllStringFunc(s, func(m string) string {
+		if m == "$${" {
+			return "${"
+		}
+		sub := reference.FindStringSubmatch(m)
+		name := sub[1]
+		if s, ok := v.Lookup(name); ok {
+			return s
+		}
+		if sub[2] != "" {
+			return sub[3]
+		}
+		if !slices.Contains(*undefined, name) {
+			*undefined = append(*undefined, name)
+		}
+		return m
+	})
+}
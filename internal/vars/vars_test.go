@@ -0,0 +1,140 @@
+package vars
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestExpand(t *testing.T) {
+	t.Setenv("VARS_TEST_ENV", "from-env")
+	values := Values{
+		"token":         "abc",
+		"port":          "8443",
+		"dn":            "cn=devs # main",
+		"VARS_TEST_ENV": "from-values",
+		"bu.name":       "payments",
+	}
+	tests := []struct {
+		name string
+		in   string
+		want map[string]any
+	}{
+		{"plain", "token: ${token}\n", map[string]any{"token": "abc"}},
+		{"environment over values", "v: ${VARS_TEST_ENV}\n", map[string]any{"v": "from-env"}},
+		{"nested name", "org: ${bu.name}-prod\n", map[string]any{"org": "payments-prod"}},
+		{"default", "v: ${UNSET_VARS_TEST:-fallback}\n", map[string]any{"v": "fallback"}},
+		{"empty default", "v: x${UNSET_VARS_TEST:-}y\n", map[string]any{"v": "xy"}},
+		{"escaped", "v: $${token}\n", map[string]any{"v": "${token}"}},
+		{"plain value retyped", "max: ${port}\n", map[string]any{"max": 8443}},
+		{"quoted value kept string", "max: \"${port}\"\n", map[string]any{"max": "8443"}},
+		{"value not parsed as yaml", "dn: ${dn}\n", map[string]any{"dn": "cn=devs # main"}},
+		{"key", "${bu.name}: 1\n", map[string]any{"payments": 1}},
+		{"undefined in comment ignored", "# ${UNSET_VARS_TEST}\nv: 1\n", map[string]any{"v": 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := values.Expand("test.yaml", []byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got map[string]any
+			if err := yaml.Unmarshal(out, &got); err != nil {
+				t.Fatalf("%v in %s", err, out)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Expand = %v, want %v", got, tt.want)
+			}
+			for k, want := range tt.want {
+				if got[k] != want {
+					t.Errorf("%s = %#v, want %#v", k, got[k], want)
+				}
+			}
+		})
+	}
+}
+
+func TestExpandKeepsComments(t *testing.T) {
+	out, err := Values{"token": "abc"}.Expand("test.yaml", []byte("# quays\ntoken: ${token} # default token\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(out); !strings.Contains(s, "# quays") || !strings.Contains(s, "# default token") {
+		t.Errorf("comments lost:\n%s", s)
+	}
+}
+
+func TestExpandMultipleDocuments(t *testing.T) {
+	out, err := Values{"a": "1"}.Expand("test.yaml", []byte("x: ${a}\n---\n---\ny: ${a}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(out))
+	var docs []map[string]any
+	for {
+		var doc map[string]any
+		if err := dec.Decode(&doc); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatalf("%v in %s", err, out)
+		}
+		docs = append(docs, doc)
+	}
+	if len(docs) != 3 || docs[0]["x"] != 1 || docs[1] != nil || docs[2]["y"] != 1 {
+		t.Errorf("documents = %v, want x, the empty document and y expanded", docs)
+	}
+}
+
+func TestExpandEmpty(t *testing.T) {
+	for _, in := range []string{"", "# nothing yet\n"} {
+		out, err := Values{}.Expand("test.yaml", []byte(in))
+		if err != nil {
+			t.Errorf("Expand(%q): %v", in, err)
+		}
+		if len(bytes.TrimSpace(out)) != 0 {
+			t.Errorf("Expand(%q) = %q, want nothing", in, out)
+		}
+	}
+}
+
+func TestExpandUndefined(t *testing.T) {
+	_, err := Values{}.Expand("org.yaml", []byte("a: ${UNSET_ONE}\nb: ${UNSET_TWO} ${UNSET_ONE}\n"))
+	if err == nil {
+		t.Fatal("no error for undefined variables")
+	}
+	if want := "org.yaml: undefined variables UNSET_ONE, UNSET_TWO"; err.Error() != want {
+		t.Errorf("error = %q, want %q", err, want)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.yaml")
+	second := filepath.Join(dir, "second.yaml")
+	os.WriteFile(first, []byte("env: prod\nbu:\n  name: payments\n  size: 3\n  public: true\nempty:\n"), 0o644)
+	os.WriteFile(second, []byte("env: dr\n"), 0o644)
+	values, err := Load(first, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"env": "dr", "bu.name": "payments", "bu.size": "3", "bu.public": "true", "empty": ""} {
+		if got, ok := values[name]; !ok || got != want {
+			t.Errorf("%s = %q (defined %t), want %q", name, got, ok, want)
+		}
+	}
+
+	list := filepath.Join(dir, "list.yaml")
+	os.WriteFile(list, []byte("hosts: [a, b]\n"), 0o644)
+	if _, err := Load(list); err == nil {
+		t.Error("no error for a list value")
+	}
+	if _, err := Load(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Error("no error for a missing values file")
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseSelector parses a label selector written as comma separated key=value pairs
+func parseSelector(s string) (map[string]string, error) {
+	selector := make(map[string]string)
+	for _, v := range strings.Split(s, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(v), "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !found || key == "" {
+			return nil, fmt.Errorf("invalid label selector %q: expected key=value[,key=value]", s)
+		}
+		selector[key] = value
+	}
+	return selector, nil
+}
+
+// matchLabels reports whether labels has every key=value of selector
+func matchLabels(selector map[string]string, labels map[string]string) bool {
+	for k, v := range selector {
+		if l, ok := labels[k]; !ok || l != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"repliquay/repliquay/pkg/quay"
+	"slices"
+	"strings"
+)
+
+// checkHostLogins checks every token of host used by orgs, organizations
+// sharing a token are checked with a single client
+func checkHostLogins(ctx context.Context, host HostToken, orgs []Organization, clients map[string]*quay.Client) (errs []error) {
+	// apply -dryrun sends no call, there is nothing to check
+	if len(orgs) == 0 || clients[orgs[0].Name].Conn.Skips(http.MethodGet) {
+		return
+	}
+	var tokens []string
+	byToken := make(map[string][]Organization)
+	for _, o := range orgs {
+		t := host.TokenFor(o.Name)
+		if _, ok := byToken[t]; !ok {
+			tokens = append(tokens, t)
+		}
+		byToken[t] = append(byToken[t], o)
+	}
+	for _, t := range tokens {
+		errs = append(errs, checkLogin(ctx, clients[byToken[t][0].Name], byToken[t])...)
+	}
+	return
+}
+
+// checkLogin verifies that the token of client is valid and has the scopes
+// needed to apply orgs, with read only api calls:
+//   - user:read, to identify the token owner
+//   - org:admin, to create robots and teams
+//   - repo:admin, to set the permissions of repositories
+//   - super:user and a superuser owner, to set up team syncing on hosts
+//     allowing it only to superusers
+//
+// Quay does not tell which of the OAuth applications of the owner a token
+// belongs to, so the scopes are checked listing the robots and permissions of
+// the existing organizations and repositories, and the registry users. The
+// owner must be admin of the existing organizations, the ones not created yet
+// and their repositories cannot be checked.
+func checkLogin(ctx context.Context, client *quay.Client, orgs []Organization) (errs []error) {
+	var names []string
+	for _, o := range orgs {
+		names = append(names, o.Name)
+	}
+	prefix := "token of organizations " + strings.Join(names, ", ")
+	fail := func(err error) {
+		if err != nil && ctx.Err() == nil {
+			errs = append(errs, fmt.Errorf("%s: %w", prefix, err))
+		}
+	}
+
+	user, err := client.GetUser(ctx)
+	switch code := statusCode(err); {
+	case err == nil:
+		owner := user.Username
+		if user.SuperUser {
+			owner += " (superuser)"
+		}
+		fmt.Printf("Host %s: token of organizations %s belongs to %s\n", client.Conn.Hostname, strings.Join(names, ", "), owner)
+	case code == http.StatusUnauthorized:
+		fail(errors.New("token rejected: invalid, expired or revoked"))
+		return
+	case code == http.StatusForbidden:
+		fail(errors.New("token lacks the user:read scope"))
+	default:
+		fail(fmt.Errorf("unable to check the token: %w", err))
+		return
+	}
+
+	caps := client.Conn.Capabilities
+	superuser := false
+	for _, o := range orgs {
+		if ldapSync && len(o.TeamsList) > 0 && caps.Supports(quay.FeatureTeamSync) && !caps.Supports(quay.FeatureNonSuperuserTeamSync) {
+			superuser = true
+		}
+	}
+
+	for _, o := range orgs {
+		fail(checkOrgAdmin(ctx, client, user, o))
+	}
+	if !superuser {
+		return
+	}
+	if user.Username != "" && !user.SuperUser {
+		fail(fmt.Errorf("team syncing requires a superuser on this host, %s is not", user.Username))
+		return
+	}
+	_, err = client.ListUsers(ctx)
+	fail(checkScope(err, "super:user", "the registry"))
+	return
+}
+
+// checkOrgAdmin verifies that the token can administer organization o and the
+// first of its repositories already created. Quay answers 403 instead of 404
+// for missing organizations and repositories to users that are not
+// superusers: an organization is new when it is not found or not visible and
+// the owner is not one of its members, and so are its repositories.
+func checkOrgAdmin(ctx context.Context, client *quay.Client, user quay.User, o Organization) error {
+	err := client.ProbeOrg(ctx, o.Name)
+	switch code := statusCode(err); {
+	case err == nil:
+	case code == http.StatusNotFound:
+		return nil
+	case code == http.StatusForbidden && !memberOf(user, o.Name):
+		return nil
+	default:
+		return checkScope(err, "org:admin", "organization "+o.Name)
+	}
+	_, err = client.ListRobots(ctx, o.Name)
+	if err := checkScope(err, "org:admin", "organization "+o.Name); err != nil {
+		return err
+	}
+	if len(o.RepoList) == 0 {
+		return nil
+	}
+	repos, err := client.ListRepos(ctx, o.Name)
+	if err != nil {
+		return fmt.Errorf("unable to list the repositories of organization %s: %w", o.Name, err)
+	}
+	for _, r := range o.RepoList {
+		if !slices.ContainsFunc(repos, func(e quay.Repository) bool { return e.Name == r.Name }) {
+			continue
+		}
+		_, err := client.ListRepoPermissions(ctx, o.Name, r.Name, quay.KindRobot)
+		return checkScope(err, "repo:admin", "repository "+o.Name+"/"+r.Name)
+	}
+	return nil
+}
+
+// memberOf reports whether user is a member of organization org
+func memberOf(user quay.User, org string) bool {
+	return slices.ContainsFunc(user.Organizations, func(o quay.UserOrgSummary) bool { return o.Name == org })
+}
+
+// checkScope describes the error of the api call checking scope on object,
+// objects not created yet cannot be checked
+func checkScope(err error, scope string, object string) error {
+	switch code := statusCode(err); {
+	case err == nil, code == http.StatusNotFound:
+		return nil
+	case code == http.StatusUnauthorized, code == http.StatusForbidden:
+		return fmt.Errorf("token lacks the %s scope or its owner is not admin of %s", scope, object)
+	}
+	return fmt.Errorf("unable to check the %s scope on %s: %w", scope, object, err)
+}
+
+// statusCode returns the status code of the Quay answer err comes from, 0 for other errors
+func statusCode(err error) int {
+	var apiErr *quay.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
@@ -1,19 +1,28 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"errors"
-	"flag"
 	"fmt"
+	"io"
 	"log"
+	"maps"
+	"net/http"
 	"os"
-	"repliquay/repliquay/internal/apicall"
+	"repliquay/repliquay/internal/metrics"
 	"repliquay/repliquay/internal/quayconfig"
+	"repliquay/repliquay/internal/report"
+	"repliquay/repliquay/internal/secrets"
+	"repliquay/repliquay/internal/vars"
+	"repliquay/repliquay/pkg/apicall"
+	"repliquay/repliquay/pkg/quay"
 	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"gopkg.in/ini.v1"
 	"gopkg.in/yaml.v3"
 )
 
@@ -22,9 +31,65 @@ type Quays struct {
 }
 
 type HostToken struct {
-	Host          string `yaml:"host"`
-	Token         string `yaml:"token"`
-	MaxConnection int    `yaml:"max_connections"`
+	Host         string              `yaml:"host"`
+	Token        string              `yaml:"token"`
+	TokenEnv     string              `yaml:"token_env"`
+	TokenFile    string              `yaml:"token_file"`
+	OrgTokens    map[string]OrgToken `yaml:"org_tokens"`
+	HostSettings `yaml:",inline"`
+}
+
+// HostSettings are the host options that can also be set in the conf file
+type HostSettings struct {
+	// Quay release of the host, overriding the detected one
+	Version       string            `yaml:"version,omitempty"`
+	MaxConnection int               `yaml:"max_connections,omitempty"`
+	Scheme        string            `yaml:"scheme,omitempty"`
+	TLS           HostTLS           `yaml:"tls,omitempty"`
+	Timeouts      HostTimeouts      `yaml:"timeouts,omitempty"`
+	Labels        map[string]string `yaml:"labels,omitempty"` // environment labels matched by organization overlays
+}
+
+// HostTimeouts overrides the global timeouts for a single host
+type HostTimeouts struct {
+	Dial     time.Duration `yaml:"dial,omitempty"`
+	TLS      time.Duration `yaml:"tls,omitempty"`
+	Response time.Duration `yaml:"response,omitempty"`
+	Request  time.Duration `yaml:"request,omitempty"`
+}
+
+// HostTLS overrides the global TLS options for a single host
+type HostTLS struct {
+	CAFile     string `yaml:"ca_file,omitempty"`
+	CertFile   string `yaml:"cert_file,omitempty"`
+	KeyFile    string `yaml:"key_file,omitempty"`
+	ServerName string `yaml:"server_name,omitempty"`
+	SkipVerify *bool  `yaml:"skip_verify,omitempty"`
+}
+
+// OrgToken is an organization specific token. It can be written as a plain
+// token string or as a mapping with token, token_env or token_file.
+type OrgToken struct {
+	Token     string `yaml:"token"`
+	TokenEnv  string `yaml:"token_env"`
+	TokenFile string `yaml:"token_file"`
+}
+
+func (t *OrgToken) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		t.Token = value.Value
+		return nil
+	}
+	type plain OrgToken
+	return value.Decode((*plain)(t))
+}
+
+// TokenFor returns the token configured for orgName, falling back to the host default token
+func (h HostToken) TokenFor(orgName string) string {
+	if t, ok := h.OrgTokens[orgName]; ok {
+		return t.Token
+	}
+	return h.Token
 }
 
 type Organization struct {
@@ -33,17 +98,29 @@ type Organization struct {
 	RepoList    []RepoStruct  `yaml:"repositories"`
 	RobotList   []RobotStruct `yaml:"robots"`
 	TeamsList   []TeamStruct  `yaml:"teams"`
+	// named permissions shared by the repositories of the organization
+	Profiles       map[string]RepoPermissionStruct `yaml:"permission_profiles,omitempty"`
+	DefaultProfile string                          `yaml:"default_profile,omitempty"`
+	Rules          []PermissionRule                `yaml:"permission_rules,omitempty"`
+	// host names or label selectors (key=value[,key=value]) of the hosts the
+	// organization is applied to, all the hosts when empty
+	Targets []string `yaml:"targets,omitempty"`
+	// set when the document patches the organization on the hosts matching the overlay selector
+	Overlay *Overlay `yaml:"overlay,omitempty"`
 }
 
 type RepoStruct struct {
 	Name           string               `yaml:"name"`
 	Mirror         bool                 `yaml:"mirror"`
+	Profile        string               `yaml:"profile,omitempty"`
 	PermissionList RepoPermissionStruct `yaml:"permissions"`
+	Remove         bool                 `yaml:"remove,omitempty"`
 }
 
 type RobotStruct struct {
 	Name        string `yaml:"name"`
 	Description string `yaml:"desc"`
+	Remove      bool   `yaml:"remove,omitempty"`
 }
 
 type TeamStruct struct {
@@ -51,6 +128,7 @@ type TeamStruct struct {
 	Description string `yaml:"description"`
 	GroupDN     string `yaml:"group_dn"`
 	Role        string `yaml:"role"`
+	Remove      bool   `yaml:"remove,omitempty"`
 }
 
 type RepoPermissionStruct struct {
@@ -61,267 +139,519 @@ type RepoPermissionStruct struct {
 type PermStruct struct {
 	Name           string `yaml:"name"`
 	Role           string `yaml:"role"`
-	PermissionKind string
-	RepoName       string
-	Organization   string
+	Remove         bool   `yaml:"remove,omitempty"`
+	PermissionKind string `yaml:"-"`
+	RepoName       string `yaml:"-"`
+	Organization   string `yaml:"-"`
 }
 
+// exit code when a signal or -timeout stops the run before completion
+const exitInterrupted = 130
+
 // vars
 var (
-	insecure    bool
-	ldapSync    bool
-	dryRun      bool
-	sleepPeriod int
-	debug       bool
-	retries     int
-	skipVerify  bool
-	clone       bool
+	insecure      bool
+	ldapSync      bool
+	dryRun        bool
+	dryRunReads   bool // plan sends the read calls of its dry run, apply -dryrun sends no call
+	sleepPeriod   int
+	debug         bool
+	retries       int
+	skipVerify    bool
+	clone         bool
+	runTimeout    time.Duration
+	reportFile    string
+	junitFile     string
+	metricsAddr   string
+	metricsFile   string
+	daemon        bool
+	interval      time.Duration
+	watchInterval time.Duration
+	healthAddr    string
+	unsupported   string
+	valuesFiles   []string
+	ageIdentity   string
+	retryPolicy   apicall.RetryPolicy
+	timeouts      apicall.Timeouts
 )
 
-func checkLogin(quayHost string, token string, hostConn *apicall.HostConnection) (login_ok bool) {
-	fmt.Println("check login")
-	retryCounter := 0
+func createPermissionList(org Organization, permissionName string) (permList []PermStruct) {
+	repoConfig, orgName := org.RepoList, org.Name
+	if debug {
+		fmt.Printf("Mapping Permission for %d repos for %s\n", len(repoConfig), permissionName)
+	}
+
+	totalPermission := 0
+	for _, v := range repoConfig {
 
-	hostConn.ApiCall(quayHost, "/api/v1/user/logs", "GET", token, "", "checking Logins", &retryCounter)
-	login_ok = true
+		var loopList []PermStruct
+		perms := org.repoPermissions(v)
+		if permissionName == "robots" {
+			loopList = perms.Robots
+		} else {
+			loopList = perms.Teams
+		}
+		if debug {
+			fmt.Printf("Mapping permission for repo: %s kind %s\n", v.Name, permissionName)
+		}
+		for _, vv := range loopList {
+			totalPermission++
+			permList = append(permList, PermStruct{Name: vv.Name, Role: vv.Role, PermissionKind: permissionName, RepoName: v.Name, Organization: orgName})
+		}
+	}
+	fmt.Printf("Total mapped %s permission for org: %d\n", permissionName, totalPermission)
 	return
 }
 
-func createOrg(quayHost string, orgList Organization, token string, hostConn *apicall.HostConnection) (status bool) {
-	retryCounter := 0
-
-	if debug {
-		fmt.Println("Creating Org...", orgList.Name)
-	}
-	hostConn.ApiCall(
-		quayHost,
-		"/api/v1/organization/",
-		"POST",
-		token,
-		`{"name":"`+orgList.Name+`"}`,
-		"create organization"+orgList.Name,
-		&retryCounter,
-	)
-	status = true
+// quaysTokenFiles returns the token files of the hosts and of their organizations
+func quaysTokenFiles(hostTokens []HostToken) (files []string) {
+	for _, v := range hostTokens {
+		if v.TokenFile != "" {
+			files = append(files, v.TokenFile)
+		}
+		for _, o := range slices.Sorted(maps.Keys(v.OrgTokens)) {
+			if f := v.OrgTokens[o].TokenFile; f != "" {
+				files = append(files, f)
+			}
+		}
+	}
 	return
 }
 
-func createRepo(quayHost string, orgName string, repoConfig []RepoStruct, token string, hostConn *apicall.HostConnection) (status bool) {
-	var wg sync.WaitGroup
-	retryCounter := 0
+// resolveTokens resolves the tokens of every host, reporting all the failures
+func resolveTokens(hostTokens []HostToken) error {
+	var errs []error
+	for i := range hostTokens {
+		errs = append(errs, hostTokens[i].resolveTokens())
+	}
+	return errors.Join(errs...)
+}
 
-	if debug {
-		fmt.Printf("Creating %d repos\n", len(repoConfig))
+// resolveTokens replaces the token references of the host and its organizations with their values
+func (v *HostToken) resolveTokens() error {
+	var errs []error
+	// the default token is optional when every org has its own token
+	if v.Token != "" || v.TokenEnv != "" || v.TokenFile != "" || len(v.OrgTokens) == 0 {
+		token, err := secrets.ResolveToken(v.Token, v.TokenEnv, v.TokenFile)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("host %s: %w", v.Host, err))
+		}
+		v.Token = token
 	}
-	for i, v := range repoConfig {
-		if debug {
-			fmt.Printf("Creating Repo %d: %s\n", i, v.Name)
+	for org, t := range v.OrgTokens {
+		token, err := secrets.ResolveToken(t.Token, t.TokenEnv, t.TokenFile)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("host %s org %s: %w", v.Host, org, err))
 		}
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			hostConn.ApiCall(
-				quayHost,
-				"/api/v1/repository",
-				"POST",
-				token,
-				`{"repository":"`+v.Name+`","visibility":"private","namespace":"`+orgName+`","description":"repository description"}`,
-				"create repository "+v.Name+" in org "+orgName,
-				&retryCounter,
-			)
-		}()
+		v.OrgTokens[org] = OrgToken{Token: token}
 	}
-	wg.Wait()
-	fmt.Println("Create " + orgName + " repos completed")
-	status = true
-	return
+	return errors.Join(errs...)
 }
 
-func createRepoPermission(quayHost string, permList []PermStruct, token string, hostConn *apicall.HostConnection) (status bool) {
-	var wg sync.WaitGroup
-	retryCounter := 0
-
-	if debug {
-		fmt.Printf("Creating %d permissions for host %s\n", len(permList), quayHost)
+func newHostConnection(v HostToken) (*apicall.HostConnection, error) {
+	h := apicall.HostConnection{Max_connections: v.MaxConnection, Hostname: v.Host}
+	h.SetGlobalVars(debug, skipVerify, dryRun, insecure, sleepPeriod, retries)
+	h.DryRunReads = dryRunReads
+	h.RetryPolicy = retryPolicy
+	h.Timeouts = timeouts
+	for _, t := range []struct {
+		host   time.Duration
+		target *time.Duration
+	}{
+		{v.Timeouts.Dial, &h.Timeouts.Dial},
+		{v.Timeouts.TLS, &h.Timeouts.TLS},
+		{v.Timeouts.Response, &h.Timeouts.Response},
+		{v.Timeouts.Request, &h.Timeouts.Request},
+	} {
+		if t.host > 0 {
+			*t.target = t.host
+		}
 	}
+	opts := apicall.TLSOptions{
+		CAFile:     v.TLS.CAFile,
+		CertFile:   v.TLS.CertFile,
+		KeyFile:    v.TLS.KeyFile,
+		ServerName: v.TLS.ServerName,
+		SkipVerify: skipVerify,
+	}
+	if v.TLS.SkipVerify != nil {
+		opts.SkipVerify = *v.TLS.SkipVerify
+	}
+	err := h.SetTLS(v.Scheme, opts)
+	return &h, err
+}
 
-	for _, v := range permList {
-		for hostConn.QueueLength >= hostConn.Max_connections {
-			time.Sleep(time.Duration(hostConn.SleepPeriod) * time.Millisecond)
-			fmt.Println("throttling connections")
-		}
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			if v.PermissionKind == "robots" {
-				hostConn.ApiCall(
-					quayHost,
-					"/api/v1/repository/"+v.Organization+"/"+v.RepoName+"/permissions/user/"+v.Organization+"+"+v.Name,
-					"PUT",
-					token,
-					`{"role":"`+v.Role+`"}`,
-					"repo "+v.RepoName+" in org "+v.Organization+" create repo permission for robot "+v.Name+" and role "+v.Role,
-					&retryCounter,
-				)
-			} else {
-				hostConn.ApiCall(
-					quayHost,
-					"/api/v1/repository/"+v.Organization+"/"+v.RepoName+"/permissions/team/"+v.Name,
-					"PUT",
-					token,
-					`{"role":"`+v.Role+`"}`,
-					"repo "+v.RepoName+" in org "+v.Organization+" create repo "+v.Name+" permission for teams "+v.Name+" and role "+v.Role,
-					&retryCounter,
-				)
+// checkOrgTokens verifies every host has a token for every organization it is targeted by
+func checkOrgTokens(hostTokens []HostToken, orgs []Organization) error {
+	var errs []error
+	for _, v := range hostTokens {
+		for _, o := range orgs {
+			if o.targetsHost(v) && v.TokenFor(o.Name) == "" {
+				errs = append(errs, fmt.Errorf("host %s: no token for organization %s and no default token", v.Host, o.Name))
 			}
-		}()
+		}
 	}
-	wg.Wait()
-	fmt.Println("Create permissions completed")
-	status = true
-	return
+	return errors.Join(errs...)
 }
 
-func createPermissionList(repoConfig []RepoStruct, permissionName string, orgName string) (permList []PermStruct) {
-	if debug {
-		fmt.Printf("Mapping Permission for %d repos for %s\n", len(repoConfig), permissionName)
+// printSummary prints the api calls of every host and the status of its phases
+func printSummary(res runResult, t1 time.Time) {
+	if res.interrupted != "" {
+		fmt.Printf("Repliquay: %s after %s. Partial summary:\n", res.interrupted, time.Since(t1))
+	}
+	printPlannedChanges(os.Stdout, res.report)
+	totals := res.report.Totals()
+	for _, host := range slices.Sorted(maps.Keys(totals)) {
+		t := totals[host]
+		planned := ""
+		if t.Planned > 0 {
+			planned = fmt.Sprintf(", %d changes planned", t.Planned)
+		}
+		fmt.Printf("Host %s: completed %d Api Call, %d failed%s\n", host, t.Actions-t.Planned, t.Failed, planned)
 	}
+	printHostTable(os.Stdout, res.runs)
+}
 
-	totalPermission := 0
-	for _, v := range repoConfig {
+// printPlannedChanges prints the writes of a dry run not sent to every host
+func printPlannedChanges(w io.Writer, r *report.Report) {
+	var host string
+	for _, e := range r.Entries() {
+		// reads skipped by apply -dryrun are not changes
+		if e.Outcome != quay.OutcomePlanned || e.Method == http.MethodGet {
+			continue
+		}
+		if e.Host != host {
+			host = e.Host
+			fmt.Fprintf(w, "Host %s: planned changes\n", host)
+		}
+		target := quay.Target{Org: e.Org, Kind: e.Kind, Name: e.Name, Operation: e.Operation}
+		fmt.Fprintf(w, "  %s: %s %s %s\n", target, e.Method, e.Path, e.Body)
+	}
+}
 
-		var loopList []PermStruct
-		if permissionName == "robots" {
-			loopList = v.PermissionList.Robots
-		} else {
-			loopList = v.PermissionList.Teams
+// writeReports writes the report and metrics files requested with -report, -junit and -metricsfile
+func writeReports(res runResult, runMetrics *metrics.Metrics) {
+	runMetrics.RunCompleted(res.interrupted == "" && len(res.failedHosts) == 0)
+	if metricsFile != "" {
+		if err := runMetrics.WriteTextfile(metricsFile); err != nil {
+			log.Printf("Unable to write metrics %s: %s", metricsFile, err)
 		}
-		if debug {
-			fmt.Printf("Mapping permission for repo: %s kind %s\n", v.Name, permissionName)
+	}
+	if reportFile != "" {
+		if err := res.report.WriteJSON(reportFile, res.interrupted); err != nil {
+			log.Printf("Unable to write report %s: %s", reportFile, err)
 		}
-		for _, vv := range loopList {
-			totalPermission++
-			permList = append(permList, PermStruct{Name: vv.Name, Role: vv.Role, PermissionKind: permissionName, RepoName: v.Name, Organization: orgName})
+	}
+	if junitFile != "" {
+		if err := res.report.WriteJUnit(junitFile); err != nil {
+			log.Printf("Unable to write JUnit report %s: %s", junitFile, err)
 		}
 	}
-	fmt.Printf("Total mapped %s permission for org: %d\n", permissionName, totalPermission)
+}
+
+func parseInts(s string) (values []int, err error) {
+	values = []int{}
+	for _, v := range strings.Split(s, ",") {
+		if strings.TrimSpace(v) == "" {
+			continue
+		}
+		i, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, i)
+	}
 	return
 }
 
-func createRobotTeam(quayHost string, orgName string, robotList []RobotStruct, teamList []TeamStruct, token string, hostConn *apicall.HostConnection) (status bool) {
-	var wg sync.WaitGroup
-	retryCounter := 0
-	// robot
-	if debug {
-		fmt.Println("creating ", len(robotList), "robots for", orgName, "host", quayHost)
+func joinInts(values []int) string {
+	var s []string
+	for _, v := range values {
+		s = append(s, strconv.Itoa(v))
 	}
-	for _, v := range robotList {
-		if debug {
-			fmt.Println("Creating robot", v)
+	return strings.Join(s, ",")
+}
+
+// runResult summarises a reconcile run
+type runResult struct {
+	report *report.Report
+	// hosts the configuration was applied to
+	hosts []string
+	// hosts with failed api calls
+	failedHosts map[string]bool
+	// reason the run stopped before completion, empty when completed
+	interrupted string
+	// phases of every host
+	runs []*hostRun
+	// token files of the quays file, watched by the daemon
+	tokenFiles []string
+}
+
+// exitCode tells whether every host, some hosts or all the hosts failed
+func (res runResult) exitCode() int {
+	switch {
+	case res.interrupted != "":
+		return exitInterrupted
+	case len(res.failedHosts) == 0:
+		return 0
+	case len(res.failedHosts) < len(res.hosts):
+		return exitSomeHostsFailed
+	}
+	return exitAllHostsFailed
+}
+
+// collectFailedHosts adds the hosts whose run failed to failedHosts
+func (res *runResult) collectFailedHosts() {
+	if res.failedHosts == nil {
+		res.failedHosts = make(map[string]bool)
+	}
+	for _, run := range res.runs {
+		if run.status() == statusFailed {
+			res.failedHosts[run.host] = true
 		}
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			hostConn.ApiCall(quayHost, "/api/v1/organization/"+orgName+"/robots/"+v.Name, "PUT", token, `{"description":"`+v.Description+`"}`, "create robot "+v.Name+" org "+orgName, &retryCounter)
-		}()
 	}
-	// wait for robot completion
-	wg.Wait()
+}
 
-	// teams
-	if debug {
-		fmt.Println("creating ", len(teamList), "teams")
+// loadOrganizations reads the organization files
+func loadOrganizations(repo []string, values vars.Values) (*orgSet, error) {
+	files, err := expandRepoFiles(repo)
+	if err != nil {
+		return nil, err
 	}
-	for _, v := range teamList {
-		if debug {
-			fmt.Println("Creating team", v)
+	set := &orgSet{profiles: make(map[string]RepoPermissionStruct), files: make(map[string]string)}
+	profileFile := make(map[string]string)
+	// overlay -> file defining it
+	overlayFile := make(map[*Overlay]string)
+	addOverlay := func(org Organization, file string) (err error) {
+		if len(org.Targets) > 0 {
+			return fmt.Errorf("overlay of organization %s in %s: targets can only be set in the organization", org.Name, file)
 		}
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			hostConn.ApiCall(quayHost, "/api/v1/organization/"+orgName+"/team/"+v.Name, "PUT", token, `{"role":"`+v.Role+`"}`, "create team "+v.Name+" org "+orgName, &retryCounter)
-			if ldapSync {
-				hostConn.ApiCall(quayHost, "/api/v1/organization/"+orgName+"/team/"+v.Name+"/syncing", "POST", token, `{"group_dn":"`+v.GroupDN+`"}`, "create team sync "+v.Name, &retryCounter)
+		if org.Overlay.selector, err = parseSelector(org.Overlay.Selector); err != nil {
+			return fmt.Errorf("overlay of organization %s in %s: %w", org.Name, file, err)
+		}
+		set.overlays = append(set.overlays, org)
+		overlayFile[org.Overlay] = file
+		return nil
+	}
+	for _, r := range files {
+		orgs, err := readOrganizations(r, values)
+		if err != nil {
+			return nil, err
+		}
+		for _, org := range orgs {
+			if isProfilesOnly(org) {
+				for name, p := range org.Profiles {
+					if _, ok := set.profiles[name]; ok {
+						return nil, fmt.Errorf("duplicated permission profile %s in %s, already defined in %s", name, r, profileFile[name])
+					}
+					set.profiles[name] = p
+					profileFile[name] = r
+				}
+				continue
 			}
-		}()
+			if org.Name == "" {
+				return nil, fmt.Errorf("organization without quay_organization in %s", r)
+			}
+			if org.Overlay != nil {
+				if err := addOverlay(org, r); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if _, ok := set.files[org.Name]; ok {
+				return nil, fmt.Errorf("duplicated organization %s in %s, already defined in %s", org.Name, r, set.files[org.Name])
+			}
+			if debug {
+				fmt.Printf("organization %s loaded from %s\n", org.Name, r)
+			}
+			set.orgs = append(set.orgs, org)
+			set.files[org.Name] = r
+		}
 	}
-	wg.Wait()
-	fmt.Println("Create robots completed")
-	status = true
-	return
+	for _, org := range confOverlays {
+		if err := addOverlay(org, confFile); err != nil {
+			return nil, err
+		}
+	}
+	for _, ov := range set.overlays {
+		if _, ok := set.files[ov.Name]; !ok {
+			return nil, fmt.Errorf("overlay %s in %s patches undefined organization %s", ov.Overlay.Selector, overlayFile[ov.Overlay], ov.Name)
+		}
+	}
+	// profiles and rules are resolved for every host, this only reports the errors early
+	for _, o := range set.orgs {
+		if _, _, err := set.effective(o, HostToken{}); err != nil {
+			return nil, err
+		}
+	}
+	return set, nil
 }
 
-func parseIniFile(inifile string, quaysfile string, repo []string, sleepPeriod int, insecure bool, ldapSync bool, dryRun bool, skipVerify bool, retries int, clone bool, debug bool) (_quaysfile string, _repo []string, _sleepPeriod int, _insecure bool, _ldapSync bool, _dryRun bool, _skipVerify bool, _retries int, _clone bool, _debug bool) {
-	inidata, err := ini.Load(inifile)
-
+// readOrganizationFile reads an organization file expanding its variables
+func readOrganizationFile(file string, values vars.Values) ([]byte, error) {
+	data, err := os.ReadFile(file)
 	if err != nil {
-		log.Print("Warning: no conf option found, loading default values")
-		_quaysfile, _repo, _sleepPeriod, _retries, _debug, _insecure, _ldapSync, _dryRun, _skipVerify, _clone = quaysfile, repo, sleepPeriod, retries, debug, insecure, ldapSync, dryRun, skipVerify, clone
-		return
+		return nil, fmt.Errorf("error while reading organization file: %w", err)
 	}
+	return values.Expand(file, data)
+}
 
-	_quaysfile = inidata.Section("quays").Key("file").String()
-	_repo = inidata.Section("repos").Key("files").Strings(",")
-	_sleepPeriod, err = inidata.Section("params").Key("sleep").Int()
+// readOrganizations decodes an organization file. Every YAML document of the
+// file holds an organization or a list of organizations, each one is decoded
+// into a new struct.
+func readOrganizations(file string, values vars.Values) (orgs []Organization, err error) {
+	data, err := readOrganizationFile(file, values)
 	if err != nil {
-		_sleepPeriod = sleepPeriod
+		return nil, err
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	for doc := 1; ; doc++ {
+		var node yaml.Node
+		err := dec.Decode(&node)
+		if errors.Is(err, io.EOF) {
+			return orgs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error while parsing organization file %s document %d: %w", file, doc, err)
+		}
+		if len(node.Content) == 0 {
+			continue
+		}
+		switch node.Content[0].Kind {
+		case yaml.SequenceNode:
+			var list []Organization
+			if err := node.Decode(&list); err != nil {
+				return nil, fmt.Errorf("error while parsing organization file %s document %d: %w", file, doc, err)
+			}
+			orgs = append(orgs, list...)
+		case yaml.MappingNode:
+			var org Organization
+			if err := node.Decode(&org); err != nil {
+				return nil, fmt.Errorf("error while parsing organization file %s document %d: %w", file, doc, err)
+			}
+			orgs = append(orgs, org)
+		case yaml.ScalarNode:
+			// empty document, e.g. a trailing ---
+			if node.Content[0].Tag == "!!null" {
+				continue
+			}
+			fallthrough
+		default:
+			return nil, fmt.Errorf("error while parsing organization file %s document %d: expected an organization or a list of organizations", file, doc)
+		}
 	}
-	_retries, err = inidata.Section("params").Key("retries").Int()
+}
+
+// cloneOrganizations reads the organizations configured on the source quay,
+// printing the progress messages on progress. The organizations are not
+// returned when any of their objects cannot be read.
+func cloneOrganizations(ctx context.Context, source HostToken, hostConn *apicall.HostConnection, progress io.Writer) (parsedOrg []Organization, orgList []string, err error) {
+	qc := quayconfig.QuayConfig{Output: progress}
+	qc.SetGlobalVars(debug, skipVerify, dryRun, insecure, sleepPeriod, retries)
+	if source.Token == "" {
+		return nil, nil, fmt.Errorf("cannot clone. %s requires a default token", source.Host)
+	}
+	org_repos, org_teams, org_robots, org_repo_perms, err := qc.GetConfFromQuay(ctx, hostConn, source.Token)
 	if err != nil {
-		_retries = retries
-	}
-	_debug, _ = inidata.Section("params").Key("debug").Bool()
-	_ldapSync, _ = inidata.Section("params").Key("ldapsync").Bool()
-	_dryRun, _ = inidata.Section("params").Key("dryrun").Bool()
-	_skipVerify, _ = inidata.Section("params").Key("skipVerify").Bool()
-	_insecure, _ = inidata.Section("params").Key("insecure").Bool()
-	_clone, _ = inidata.Section("params").Key("clone").Bool()
+		return nil, nil, fmt.Errorf("unable to read the organizations of %s\n%w", source.Host, err)
+	}
+
+	for k, r := range org_repos {
+		var robotList []RobotStruct
+		var teamList []TeamStruct
+		var repoList []RepoStruct
+		for _, v := range org_robots[k] {
+			robotList = append(robotList, RobotStruct{Name: v.Name, Description: v.Description})
+		}
+		for _, v := range org_teams[k] {
+			teamList = append(teamList, TeamStruct{Name: v.Name, Description: v.Description, GroupDN: "", Role: v.Role})
+		}
+
+		for _, v := range r {
+			var robotPerms, teamsPerms []PermStruct
+			for _, p := range org_repo_perms[k][v] {
+				// var kind, n, rp string
+				perm := strings.Split(p, "#")
+				kind, n, rp := perm[0], perm[1], perm[2]
+				if kind == "robot" {
+					robotPerms = append(robotPerms, PermStruct{Name: n, Role: rp, PermissionKind: "robots", RepoName: v, Organization: k})
+				} else {
+					teamsPerms = append(teamsPerms, PermStruct{Name: n, Role: rp, PermissionKind: "teams", RepoName: v, Organization: k})
+				}
+			}
+			repoList = append(repoList, RepoStruct{Mirror: false, Name: v, PermissionList: RepoPermissionStruct{Robots: robotPerms, Teams: teamsPerms}})
+		}
+		parsedOrg = append(parsedOrg, Organization{Name: k, OrgRoleName: k, RobotList: robotList, TeamsList: teamList, RepoList: repoList})
+		orgList = append(orgList, k)
+	}
 	return
 }
 
-func main() {
+// loadQuays reads the quays file, decrypting it and expanding its variables,
+// and resolves the tokens of every host
+func loadQuays(quaysfile string, values vars.Values) ([]HostToken, error) {
 	var quays Quays
-	var org Organization
-	var parsedOrg []Organization
-	var permList []PermStruct
-	hostConn := make(map[string]*apicall.HostConnection)
+	yamlData, err := secrets.ReadFile(quaysfile, ageIdentity)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading quays file: %w", err)
+	}
+	if yamlData, err = values.Expand(quaysfile, yamlData); err != nil {
+		return nil, fmt.Errorf("error while expanding quays file: %w", err)
+	}
+	if err := yaml.Unmarshal(yamlData, &quays); err != nil {
+		return nil, fmt.Errorf("error while parsing quays file %s: %w", quaysfile, err)
+	}
+	if err := checkUniqueHosts(quays.HostToken); err != nil {
+		return nil, fmt.Errorf("error while parsing quays file %s\n%w", quaysfile, err)
+	}
+	if err := applyHostSettings(quays.HostToken, confHosts); err != nil {
+		return nil, fmt.Errorf("error while applying conf file host settings\n%w", err)
+	}
+	if err := checkVersions(quays.HostToken); err != nil {
+		return nil, fmt.Errorf("error while parsing quays file %s\n%w", quaysfile, err)
+	}
+	return quays.HostToken, nil
+}
 
-	var (
-		quaysfile string
-		repo      []string
-		confFile  string
-	)
+// checkUniqueHosts verifies every host is listed once in the quays file
+func checkUniqueHosts(hosts []HostToken) error {
+	var errs []error
+	seen := make(map[string]bool)
+	for _, v := range hosts {
+		if seen[v.Host] {
+			errs = append(errs, fmt.Errorf("host %s is listed more than once", v.Host))
+		}
+		seen[v.Host] = true
+	}
+	return errors.Join(errs...)
+}
 
-	t1 := time.Now()
-	flag.Func("repo", "quay repo file name", func(s string) error {
-		_, err := os.Stat(s)
-		if err == nil {
-			repo = append(repo, s)
-			return nil
-		} else {
-			return errors.New("file does not exists")
+// checkVersions verifies the declared releases of the hosts
+func checkVersions(hosts []HostToken) error {
+	var errs []error
+	for _, v := range hosts {
+		if v.Version == "" {
+			continue
+		}
+		if err := quay.CheckRelease(v.Version); err != nil {
+			errs = append(errs, fmt.Errorf("host %s: %w", v.Host, err))
 		}
-	})
-	flag.StringVar(&quaysfile, "quaysfile", "", "quay token file name")
-	flag.StringVar(&confFile, "conf", "/repos/repliquay.conf", "repliquay config file (override all opts)")
-	flag.IntVar(&sleepPeriod, "sleep", 100, "sleep length ms when reaching max connection")
-	flag.IntVar(&retries, "retries", 3, "max retries on api call failure")
-	flag.BoolVar(&debug, "debug", false, "print debug messages (default false)")
-	flag.BoolVar(&insecure, "insecure", false, "disable TLS connection (default false)")
-	flag.BoolVar(&ldapSync, "ldapsync", false, "enable ldap sync (default false)")
-	flag.BoolVar(&dryRun, "dryrun", false, "enable dry run (default false)")
-	flag.BoolVar(&skipVerify, "skipVerify", false, "enable/disable TLS validation")
-	flag.BoolVar(&clone, "clone", false, "clone first quay configuration to others. Requires >= 2 quays (ignore all other options)")
-
-	flag.Parse()
-
-	p, _ := os.Executable()
-	_, err := os.Stat(p + "/" + confFile)
-	if err != nil {
-		quaysfile, repo, sleepPeriod, insecure, ldapSync, dryRun, skipVerify, retries, clone, debug = parseIniFile(confFile, quaysfile, repo, sleepPeriod, insecure, ldapSync, dryRun, skipVerify, retries, clone, debug)
-	} else {
-		fmt.Println("No config file provided ")
 	}
+	return errors.Join(errs...)
+}
+
+// reconcile applies the organizations to every host of the quays file, skipping
+// the hosts skipHost returns true for. Errors are returned only for invalid
+// configurations, failed api calls are recorded in the run report.
+func reconcile(ctx context.Context, quaysfile string, repo []string, skipHost func(string) bool, runMetrics *metrics.Metrics) (res runResult, err error) {
+	var quays Quays
+	var set *orgSet
+	hostConn := make(map[string]*apicall.HostConnection)
+	// host -> organization -> client using the organization token
+	clients := make(map[string]map[string]*quay.Client)
+	res.report = report.New(dryRun)
+	res.failedHosts = make(map[string]bool)
 
 	if debug {
 		for _, v := range repo {
@@ -330,125 +660,274 @@ func main() {
 		fmt.Printf("quayfile %s\n\ninsecure %t\n", quaysfile, insecure)
 	}
 
-	yamlData, err := os.ReadFile(quaysfile)
-
+	values, err := vars.Load(valuesFiles...)
 	if err != nil {
-		log.Fatal("Error while reading quays file ", err)
+		return res, err
+	}
+	if quays.HostToken, err = loadQuays(quaysfile, values); err != nil {
+		return res, err
+	}
+	res.tokenFiles = quaysTokenFiles(quays.HostToken)
+	// host -> error configuring its connection
+	connErrs := make(map[string]error)
+	runMetrics.ResetHosts()
+	for _, v := range quays.HostToken {
+		h, err := newHostConnection(v)
+		connErrs[v.Host] = err
+		hostConn[v.Host] = h
+		defer h.Close()
+		if err := runMetrics.AddHost(h); err != nil {
+			return res, err
+		}
 	}
 
-	yaml.Unmarshal(yamlData, &quays)
-	var orgList []string
 	if !clone {
-		for _, r := range repo {
-			yamlData, err = os.ReadFile(r)
-			if err != nil {
-				log.Fatal("Error while reading quays file ", err)
-			}
-			yaml.Unmarshal(yamlData, &org)
-			if slices.Contains(orgList, org.Name) {
-				log.Fatalf("Duplicated organization %s", org.Name)
-			} else {
-				parsedOrg = append(parsedOrg, org)
-				orgList = append(orgList, org.Name)
-			}
+		set, err = loadOrganizations(repo, values)
+		if err != nil {
+			return res, err
 		}
 	} else {
-		var qc quayconfig.QuayConfig
-		parsedOrg = nil
-		orgList = nil
-		qc.SetGlobalVars(debug, skipVerify, dryRun, insecure, sleepPeriod, retries)
 		if len(quays.HostToken) < 2 {
-			log.Fatalf("Cannot clone. 2 quays registry required, got %d", len(quays.HostToken))
+			return res, fmt.Errorf("cannot clone. 2 quays registry required, got %d", len(quays.HostToken))
+		}
+		if err := connErrs[quays.HostToken[0].Host]; err != nil {
+			return res, fmt.Errorf("error while configuring the clone source connection\n%w", err)
+		}
+		if err := quays.HostToken[0].resolveTokens(); err != nil {
+			return res, fmt.Errorf("error while resolving the clone source token\n%w", err)
 		}
 		log.Printf("Cloning repository %s to %s", quays.HostToken[0].Host, quays.HostToken[1].Host)
-		org_repos, org_teams, org_robots, org_repo_perms := qc.GetConfFromQuay(quays.HostToken[0].Host, quays.HostToken[0].Token, quays.HostToken[0].MaxConnection)
+		var parsedOrg []Organization
+		parsedOrg, _, err = cloneOrganizations(ctx, quays.HostToken[0], hostConn[quays.HostToken[0].Host], os.Stdout)
+		set = &orgSet{orgs: parsedOrg}
+		if ctx.Err() != nil {
+			res.interrupted = interruptReason(ctx, "clone source read")
+			return res, nil
+		}
+		if err != nil {
+			return res, err
+		}
 
 		//remove first quay instance as cloning from first to others
 		_, tempQuay := quays.HostToken[0], quays.HostToken[1:]
 		quays.HostToken = tempQuay
+	}
 
-		for k, r := range org_repos {
-			var robotList []RobotStruct
-			var teamList []TeamStruct
-			var repoList []RepoStruct
-			for _, v := range org_robots[k] {
-				robotList = append(robotList, RobotStruct{Name: v.Name, Description: v.Description})
-			}
-			for _, v := range org_teams[k] {
-				teamList = append(teamList, TeamStruct{Name: v.Name, Description: v.Description, GroupDN: "", Role: v.Role})
-			}
-
-			for _, v := range r {
-				var robotPerms, teamsPerms []PermStruct
-				for _, p := range org_repo_perms[k][v] {
-					// var kind, n, rp string
-					perm := strings.Split(p, "#")
-					kind, n, rp := perm[0], perm[1], perm[2]
-					if kind == "robot" {
-						robotPerms = append(robotPerms, PermStruct{Name: n, Role: rp, PermissionKind: "robots", RepoName: v, Organization: k})
-					} else {
-						teamsPerms = append(teamsPerms, PermStruct{Name: n, Role: rp, PermissionKind: "teams", RepoName: v, Organization: k})
-					}
-				}
-				repoList = append(repoList, RepoStruct{Mirror: false, Name: v, PermissionList: RepoPermissionStruct{Robots: robotPerms, Teams: teamsPerms}})
-			}
-			parsedOrg = append(parsedOrg, Organization{Name: k, OrgRoleName: k, RobotList: robotList, TeamsList: teamList, RepoList: repoList})
-		}
+	if set.orgs, err = filterOrganizations(set.orgs); err != nil {
+		return res, err
+	}
+	if err := checkTargets(set.orgs, quays.HostToken); err != nil {
+		return res, fmt.Errorf("error while checking organization targets\n%w", err)
 	}
 
-	fmt.Printf("Repliquay: repliquayting... be patient\n")
+	if quays.HostToken, err = filterHosts(quays.HostToken); err != nil {
+		return res, err
+	}
+	quays.HostToken = slices.DeleteFunc(quays.HostToken, func(v HostToken) bool {
+		if skipHost(v.Host) {
+			log.Printf("Skipping host %s", v.Host)
+			return true
+		}
+		return false
+	})
 
-	var wg sync.WaitGroup
-	for _, o := range parsedOrg {
-		// fmt.Printf("Parsed ORG------%s\n", o.Name)
-		permList = slices.Concat(permList, createPermissionList(o.RepoList, "robots", o.Name), createPermissionList(o.RepoList, "teams", o.Name))
+	// the errors of a host only stop its own pipeline
+	runs := make(map[string]*hostRun)
+	// host -> organizations with the overlays matching the host applied
+	hostOrgs := make(map[string][]Organization)
+	for i := range quays.HostToken {
+		v := &quays.HostToken[i]
+		res.hosts = append(res.hosts, v.Host)
+		run := newHostRun(v.Host)
+		runs[v.Host] = run
+		res.runs = append(res.runs, run)
+
+		run.start(phaseSetup)
+		err := connErrs[v.Host]
+		if err == nil {
+			err = v.resolveTokens()
+		}
+		if err == nil {
+			err = checkOrgTokens([]HostToken{*v}, set.orgs)
+		}
+		if err == nil {
+			var applied map[string][]string
+			hostOrgs[v.Host], applied, err = set.forHost(*v)
+			printHostOrganizations(*v, hostOrgs[v.Host], applied)
+		}
+		run.end(ctx, err)
 	}
 
+	// capabilities and tokens of every host are checked before the first change
+	var wg sync.WaitGroup
 	for _, v := range quays.HostToken {
-		h := apicall.HostConnection{QueueLength: 0, Max_connections: v.MaxConnection, Hostname: v.Host}
-		h.SetGlobalVars(debug, skipVerify, dryRun, insecure, sleepPeriod, retries)
-		hostConn[v.Host] = &h
+		run := runs[v.Host]
+		if !run.running() {
+			continue
+		}
+		onAction := func(a quay.Action) {
+			res.report.Add(a)
+			runMetrics.ObserveAction(a)
+			run.observe(a)
+		}
+		clients[v.Host] = make(map[string]*quay.Client)
+		for _, org := range hostOrgs[v.Host] {
+			o := org.Name
+			clients[v.Host][o] = quay.New(hostConn[v.Host], v.TokenFor(o))
+			clients[v.Host][o].OnAction = onAction
+		}
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			if !dryRun {
-				if !checkLogin(v.Host, v.Token, hostConn[v.Host]) {
-					log.Fatal("Error logging to quay hosts")
-				}
+			run.start(phaseCapabilities)
+			detectCapabilities(ctx, v, hostConn[v.Host], onAction)
+			if !run.end(ctx, checkFeatures(v, hostConn[v.Host], hostOrgs[v.Host])) {
+				return
 			}
+			run.start(phaseLogin)
+			run.end(ctx, errors.Join(checkHostLogins(ctx, v, hostOrgs[v.Host], clients[v.Host])...))
 		}()
 	}
 	wg.Wait()
+	if ctx.Err() != nil {
+		res.interrupted = interruptReason(ctx, "capabilities and login check")
+		return res, nil
+	}
 
+	fmt.Printf("Repliquay: repliquayting... be patient\n")
 	for _, v := range quays.HostToken {
-		fmt.Println("len parsedOrg", len(parsedOrg))
-		for _, o := range parsedOrg {
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				fmt.Printf("creating organization - Host: %s\t- %s\n", v.Host, o.Name)
-				createOrg(v.Host, o, v.Token, hostConn[v.Host])
-				fmt.Printf("creating robots and teams for organization %s - Host: %s\n", o.Name, v.Host)
-				createRobotTeam(v.Host, o.Name, o.RobotList, o.TeamsList, v.Token, hostConn[v.Host])
-				fmt.Printf("creating repositories for organization %s - Host: %s\n", o.Name, v.Host)
-				createRepo(v.Host, o.Name, o.RepoList, v.Token, hostConn[v.Host])
-			}()
+		run := runs[v.Host]
+		if !run.running() {
+			log.Printf("Host %s failed its checks, skipping it", v.Host)
+			continue
 		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			applyHost(ctx, run, hostOrgs[v.Host], hostConn[v.Host], clients[v.Host])
+		}()
 	}
 	wg.Wait()
-	for _, v := range quays.HostToken {
-		fmt.Println("len parsedOrg", len(parsedOrg))
-		for i, o := range parsedOrg {
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				if i == 0 {
-					fmt.Printf("creating permissions for repositories in organization %s - Host: %s\n", o.Name, v.Host)
-					createRepoPermission(v.Host, permList, v.Token, hostConn[v.Host])
+	if ctx.Err() != nil {
+		res.interrupted = interruptReason(ctx, "organizations and permissions creation")
+	}
+	res.collectFailedHosts()
+	return res, nil
+}
+
+// applyHost creates the organizations, robots, teams and repositories of a
+// host, then the repository permissions
+func applyHost(ctx context.Context, run *hostRun, orgs []Organization, hostConn *apicall.HostConnection, clients map[string]*quay.Client) {
+	run.startGraph(phaseObjects, phasePermissions)
+	g := hostGraph(orgs, clients)
+	workers := hostConn.MaxConnections()
+	fmt.Printf("applying %d organizations as %d tasks on %d workers - Host: %s\n", len(orgs), len(g.tasks), workers, hostConn.Hostname)
+	g.execute(ctx, workers)
+	if debug {
+		for _, t := range g.tasks {
+			switch t.state {
+			case taskFailed:
+				fmt.Printf("Host %s: %s failed: %s\n", hostConn.Hostname, t.name, t.err)
+			case taskSkipped:
+				fmt.Printf("Host %s: %s skipped, %s failed\n", hostConn.Hostname, t.name, t.cause.name)
+			}
+		}
+	}
+	run.endGraph(ctx, g)
+}
+
+// hostGraph models the work of a host: every organization comes first, then
+// its robots, teams and repositories, then the team syncing and the
+// permissions of every repository once the repository and the robot or team
+// it grants exist. The permissions of the existing_repos rules are added by a
+// task listing the repositories once the organization exists. Objects already
+// existing on the host count as created.
+func hostGraph(orgs []Organization, clients map[string]*quay.Client) *graph {
+	var g graph
+	created := func(err error) error {
+		if quay.IsAlreadyExists(err) {
+			return nil
+		}
+		return err
+	}
+	for _, o := range orgs {
+		client := clients[o.Name]
+		org := g.add(phaseObjects, "organization "+o.Name, func(ctx context.Context) error {
+			return created(client.CreateOrg(ctx, o.Name))
+		})
+
+		robots := make(map[string]*task)
+		for _, v := range o.RobotList {
+			robots[v.Name] = g.add(phaseObjects, "robot "+quay.RobotFullName(o.Name, v.Name), func(ctx context.Context) error {
+				return created(client.PutRobot(ctx, o.Name, v.Name, v.Description))
+			}, org)
+		}
+		teams := make(map[string]*task)
+		for _, v := range o.TeamsList {
+			team := g.add(phaseObjects, "team "+o.Name+"/"+v.Name, func(ctx context.Context) error {
+				return client.PutTeam(ctx, o.Name, v.Name, quay.TeamRequest{Role: v.Role, Description: v.Description})
+			}, org)
+			teams[v.Name] = team
+			if ldapSync && client.Conn.Capabilities.Supports(quay.FeatureTeamSync) {
+				g.add(phaseObjects, "team sync "+o.Name+"/"+v.Name, func(ctx context.Context) error {
+					return client.SyncTeam(ctx, o.Name, v.Name, v.GroupDN)
+				}, team)
+			}
+		}
+		repos := make(map[string]*task)
+		for _, v := range o.RepoList {
+			repos[v.Name] = g.add(phaseObjects, "repository "+o.Name+"/"+v.Name, func(ctx context.Context) error {
+				return created(client.CreateRepo(ctx, quay.CreateRepoRequest{
+					Namespace:   o.Name,
+					Repository:  v.Name,
+					Visibility:  "private",
+					Description: "repository description",
+				}))
+			}, org)
+		}
+
+		printRuleMatches(o)
+		addPermissions := func(add addFunc, perms []PermStruct) {
+			for _, v := range perms {
+				kind, grantee := quay.KindTeam, teams[v.Name]
+				if v.PermissionKind == "robots" {
+					kind, grantee = quay.KindRobot, robots[v.Name]
+				}
+				// existing repositories only wait for their organization
+				repo := repos[v.RepoName]
+				if repo == nil {
+					repo = org
+				}
+				add(phasePermissions, "permission "+o.Name+"/"+quay.PermissionName(v.RepoName, kind, v.Name, v.Role), func(ctx context.Context) error {
+					return client.SetRepoPermission(ctx, o.Name, v.RepoName, kind, v.Name, v.Role)
+				}, repo, grantee)
+			}
+		}
+		addPermissions(g.add, slices.Concat(createPermissionList(o, "robots"), createPermissionList(o, "teams")))
+		if slices.ContainsFunc(o.Rules, func(r PermissionRule) bool { return r.Existing }) {
+			// the existing repositories are listed once the organization exists,
+			// a failed listing fails the permissions of the organization
+			g.addExpand(phasePermissions, "permission rules of organization "+o.Name, func(ctx context.Context, add addFunc) error {
+				existing, err := existingRepoPermissions(ctx, o, client)
+				if err != nil {
+					return err
 				}
-			}()
+				addPermissions(add, existing)
+				return nil
+			}, org)
 		}
 	}
-	wg.Wait()
-	fmt.Printf("Repliquay: mission completed in %s\n", time.Since(t1))
+	return &g
+}
+
+func interruptReason(ctx context.Context, phase string) string {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return "timeout reached during " + phase
+	}
+	return "interrupted by signal during " + phase
+}
+
+func main() {
+	cmd := parseCommand(os.Args[1:])
+	os.Exit(cmd.run())
 }
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/ini.v1"
+)
+
+// options are layered, from lowest to highest precedence:
+// flag defaults < conf file < REPLIQUAY_* environment variables < command line flags
+const (
+	envPrefix     = "REPLIQUAY_"
+	sourceDefault = "default"
+	sourceFlag    = "flag"
+)
+
+// defaultConfFile is read when it exists and no conf file is set
+const defaultConfFile = "/repos/repliquay.conf"
+
+// confKeys maps the keys of the conf file sections to their options,
+// [params] keys have the name of the option
+var confKeys = map[string]string{
+	"quays.file":            "quaysfile",
+	"repos.files":           "repo",
+	"repos.values":          "values",
+	"filters.hosts":         "hosts",
+	"filters.labels":        "hostlabels",
+	"filters.organizations": "orgs",
+	"output.report":         "report",
+	"output.junit":          "junit",
+	"output.metricsfile":    "metricsfile",
+	"output.metricsaddr":    "metricsaddr",
+}
+
+var (
+	// command and arguments the options were parsed from, kept to reload them
+	cliCommand command
+	cliArgs    []string
+	// option -> layer its value comes from
+	optionSources map[string]string
+	// lower case option name -> option, for the options of every command
+	knownOptions map[string]string
+)
+
+// listFlag is a repeatable option whose values can also be given comma separated,
+// as conf files and environment variables do
+type listFlag struct {
+	values *[]string
+	check  func(string) error
+}
+
+func (l listFlag) String() string {
+	if l.values == nil {
+		return ""
+	}
+	return strings.Join(*l.values, ",")
+}
+
+func (l listFlag) Set(s string) error {
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if l.check != nil {
+			if err := l.check(v); err != nil {
+				return err
+			}
+		}
+		*l.values = append(*l.values, v)
+	}
+	return nil
+}
+
+// loadOptions registers the options of cmd on a new flag set, resetting them
+// to their defaults, and applies the conf file, the environment and args
+func loadOptions(cmd command, args []string) (*flag.FlagSet, error) {
+	fs := flag.NewFlagSet("repliquay "+cmd.name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: repliquay %s [options]\n\n%s\n\nOptions:\n", cmd.name, cmd.summary)
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\nEvery option can also be set in the conf file or with a %s<OPTION> environment variable (e.g. %sQUAYSFILE).\n", envPrefix, envPrefix)
+		if cmd.name == "apply" {
+			fmt.Fprintln(fs.Output())
+			usage(fs.Output())
+		}
+	}
+	knownOptions = optionNames()
+	cmd.flags(fs)
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "unexpected argument %q\n", fs.Arg(0))
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	return fs, applyLayers(fs)
+}
+
+// optionNames returns the options of every command by lower case name. It
+// registers the options on throw-away flag sets, resetting them to their
+// defaults, so it must be called before the options are parsed.
+func optionNames() map[string]string {
+	names := make(map[string]string)
+	for _, c := range commands {
+		fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+		c.flags(fs)
+		fs.VisitAll(func(f *flag.Flag) { names[strings.ToLower(f.Name)] = f.Name })
+	}
+	return names
+}
+
+// paramOption returns the option set by a [params] key of the conf file.
+// Keys are matched ignoring case, as environment variables are.
+func paramOption(key string) (string, bool) {
+	name, ok := knownOptions[strings.ToLower(key)]
+	return name, ok
+}
+
+// reloadOptions reads the conf file again, options set by flags or environment
+// variables keep their value
+func reloadOptions() error {
+	_, err := loadOptions(cliCommand, cliArgs)
+	return err
+}
+
+func applyLayers(fs *flag.FlagSet) error {
+	optionSources = make(map[string]string)
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+		optionSources[f.Name] = sourceFlag
+	})
+
+	var errs []error
+	set := func(f *flag.Flag, value string, source string) {
+		if err := fs.Set(f.Name, value); err != nil {
+			errs = append(errs, fmt.Errorf("invalid value %q for option %s from %s: %w", value, f.Name, source, err))
+			return
+		}
+		optionSources[f.Name] = source
+	}
+	env := make(map[string]bool)
+	fs.VisitAll(func(f *flag.Flag) {
+		if explicit[f.Name] {
+			return
+		}
+		name := envPrefix + strings.ToUpper(f.Name)
+		if v, ok := os.LookupEnv(name); ok {
+			env[f.Name] = true
+			set(f, v, "env "+name)
+		}
+	})
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	if fs.Lookup("conf") != nil {
+		conf, err := readConf(confFile, explicit["conf"] || env["conf"])
+		if err != nil {
+			return err
+		}
+		fs.VisitAll(func(f *flag.Flag) {
+			v, ok := conf[f.Name]
+			if !ok || explicit[f.Name] || env[f.Name] {
+				return
+			}
+			set(f, v, "conf "+confFile)
+		})
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		if _, ok := optionSources[f.Name]; !ok {
+			optionSources[f.Name] = sourceDefault
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// readConf reads the options of a conf file, YAML or TOML according to its
+// extension and ini otherwise. A missing conf file is an error only when it
+// was set with -conf or $REPLIQUAY_CONF.
+func readConf(path string, required bool) (map[string]string, error) {
+	confHosts, confOverlays = nil, nil
+	if _, err := os.Stat(path); err != nil && !required {
+		if debug {
+			fmt.Printf("No config file %s, using flags and environment\n", path)
+		}
+		return nil, nil
+	}
+	switch confFormat(path) {
+	case formatYAML, formatTOML:
+		return readStructuredConf(path)
+	}
+	return readIniConf(path)
+}
+
+// readIniConf reads the options of an ini conf file
+func readIniConf(path string) (map[string]string, error) {
+	data, err := ini.Load(path)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading conf file: %w", err)
+	}
+	conf := make(map[string]string)
+	for _, section := range data.Sections() {
+		for _, key := range section.Keys() {
+			var name string
+			var ok bool
+			switch section.Name() {
+			case "params", ini.DefaultSection:
+				if name, ok = paramOption(key.Name()); !ok {
+					log.Printf("Warning: unknown option %s in section [%s] of %s", key.Name(), section.Name(), path)
+					continue
+				}
+			default:
+				if name, ok = confKeys[section.Name()+"."+key.Name()]; !ok {
+					log.Printf("Warning: unknown key %s in section [%s] of %s", key.Name(), section.Name(), path)
+					continue
+				}
+			}
+			conf[name] = key.Value()
+		}
+	}
+	return conf, nil
+}
+
+// showOptions prints the value of every option and the layer it comes from
+func showOptions(w io.Writer, fs *flag.FlagSet) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "OPTION\tVALUE\tSOURCE")
+	var names []string
+	fs.VisitAll(func(f *flag.Flag) { names = append(names, f.Name) })
+	slices.Sort(names)
+	for _, name := range names {
+		value := fs.Lookup(name).Value.String()
+		if value == "" {
+			value = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", name, value, optionSources[name])
+	}
+	tw.Flush()
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// clearEnv unsets the REPLIQUAY_* variables of the test environment for the duration of the test
+func clearEnv(t *testing.T) {
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(name, envPrefix) {
+			t.Setenv(name, "")
+			os.Unsetenv(name)
+		}
+	}
+}
+
+func writeFile(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestOptionLayers(t *testing.T) {
+	ini := `[quays]
+file = conf.yaml
+[filters]
+hosts = a, b
+[params]
+ldapsync = true
+Retries = 7
+`
+	yml := `quays:
+  file: conf.yaml
+filters:
+  hosts: [a, b]
+params:
+  ldapsync: true
+  retries: 7
+`
+	tests := []struct {
+		name string
+		// conf file name and content, set with REPLIQUAY_CONF when not empty
+		confName, conf string
+		env            map[string]string
+		args           []string
+
+		quaysfile string
+		hosts     []string
+		ldapSync  bool
+		retries   int
+		// option -> expected source, conf sources are matched by prefix
+		sources map[string]string
+	}{
+		{
+			name:      "defaults",
+			quaysfile: "", hosts: nil, ldapSync: false, retries: 3,
+			sources: map[string]string{"quaysfile": sourceDefault, "hosts": sourceDefault, "ldapsync": sourceDefault, "retries": sourceDefault},
+		},
+		{
+			name:     "ini conf file over defaults",
+			confName: "repliquay.conf", conf: ini,
+			quaysfile: "conf.yaml", hosts: []string{"a", "b"}, ldapSync: true, retries: 7,
+			sources: map[string]string{"quaysfile": "conf ", "hosts": "conf ", "ldapsync": "conf ", "retries": "conf "},
+		},
+		{
+			name:     "yaml conf file over defaults",
+			confName: "repliquay.yaml", conf: yml,
+			quaysfile: "conf.yaml", hosts: []string{"a", "b"}, ldapSync: true, retries: 7,
+			sources: map[string]string{"quaysfile": "conf ", "hosts": "conf ", "ldapsync": "conf ", "retries": "conf "},
+		},
+		{
+			name:     "environment over conf file",
+			confName: "repliquay.conf", conf: ini,
+			env:       map[string]string{"REPLIQUAY_QUAYSFILE": "env.yaml", "REPLIQUAY_HOSTS": "c", "REPLIQUAY_LDAPSYNC": "false"},
+			quaysfile: "env.yaml", hosts: []string{"c"}, ldapSync: false, retries: 7,
+			sources: map[string]string{"quaysfile": "env REPLIQUAY_QUAYSFILE", "hosts": "env REPLIQUAY_HOSTS", "ldapsync": "env REPLIQUAY_LDAPSYNC", "retries": "conf "},
+		},
+		{
+			name:     "flags over environment and conf file",
+			confName: "repliquay.conf", conf: ini,
+			env:       map[string]string{"REPLIQUAY_QUAYSFILE": "env.yaml", "REPLIQUAY_HOSTS": "c", "REPLIQUAY_RETRIES": "9"},
+			args:      []string{"-quaysfile", "flag.yaml", "-hosts", "d", "-hosts", "e,f", "-ldapsync=false"},
+			quaysfile: "flag.yaml", hosts: []string{"d", "e", "f"}, ldapSync: false, retries: 9,
+			sources: map[string]string{"quaysfile": sourceFlag, "hosts": sourceFlag, "ldapsync": sourceFlag, "retries": "env REPLIQUAY_RETRIES"},
+		},
+		{
+			name:      "bool flag without conf file",
+			env:       map[string]string{"REPLIQUAY_LDAPSYNC": "false"},
+			args:      []string{"-ldapsync"},
+			quaysfile: "", hosts: nil, ldapSync: true, retries: 3,
+			sources: map[string]string{"ldapsync": sourceFlag},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			if tt.conf != "" {
+				t.Setenv("REPLIQUAY_CONF", writeFile(t, tt.confName, tt.conf))
+			}
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			if _, err := loadOptions(commands[0], tt.args); err != nil {
+				t.Fatal(err)
+			}
+			if quaysfile != tt.quaysfile {
+				t.Errorf("quaysfile = %q, want %q", quaysfile, tt.quaysfile)
+			}
+			if !slices.Equal(onlyHosts, tt.hosts) {
+				t.Errorf("hosts = %q, want %q", onlyHosts, tt.hosts)
+			}
+			if ldapSync != tt.ldapSync {
+				t.Errorf("ldapsync = %t, want %t", ldapSync, tt.ldapSync)
+			}
+			if retries != tt.retries {
+				t.Errorf("retries = %d, want %d", retries, tt.retries)
+			}
+			for name, want := range tt.sources {
+				if got := optionSources[name]; got != want && !(strings.HasSuffix(want, " ") && strings.HasPrefix(got, want)) {
+					t.Errorf("source of %s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestConfFileSelection(t *testing.T) {
+	clearEnv(t)
+	env := writeFile(t, "env.conf", "[quays]\nfile = env.yaml\n")
+	flag := writeFile(t, "flag.conf", "[quays]\nfile = flag.yaml\n")
+	missing := filepath.Join(t.TempDir(), "missing.conf")
+
+	t.Setenv("REPLIQUAY_CONF", env)
+	if _, err := loadOptions(commands[0], nil); err != nil {
+		t.Fatal(err)
+	}
+	if quaysfile != "env.yaml" || optionSources["quaysfile"] != "conf "+env {
+		t.Errorf("REPLIQUAY_CONF: quaysfile = %q from %q, want env.yaml from conf %s", quaysfile, optionSources["quaysfile"], env)
+	}
+
+	if _, err := loadOptions(commands[0], []string{"-conf", flag}); err != nil {
+		t.Fatal(err)
+	}
+	if quaysfile != "flag.yaml" {
+		t.Errorf("-conf over REPLIQUAY_CONF: quaysfile = %q, want flag.yaml", quaysfile)
+	}
+
+	t.Setenv("REPLIQUAY_CONF", missing)
+	if _, err := loadOptions(commands[0], nil); err == nil {
+		t.Error("missing conf file set by REPLIQUAY_CONF: no error")
+	}
+	if _, err := loadOptions(commands[0], []string{"-conf", missing}); err == nil {
+		t.Error("missing conf file set by -conf: no error")
+	}
+}
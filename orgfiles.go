@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"repliquay/repliquay/internal/vars"
+	"slices"
+	"strings"
+)
+
+// isGlob reports whether a --repo entry is a glob pattern
+func isGlob(s string) bool {
+	return strings.ContainsAny(s, "*?[")
+}
+
+// checkRepoArg validates a --repo entry: an existing file or directory, or a glob pattern
+func checkRepoArg(s string) error {
+	if isGlob(s) {
+		if _, err := filepath.Match(s, ""); err != nil {
+			return fmt.Errorf("invalid pattern %s: %w", s, err)
+		}
+		return nil
+	}
+	if _, err := os.Stat(s); err != nil {
+		return errors.New("file does not exists")
+	}
+	return nil
+}
+
+// isOrgFile reports whether a file found in a directory is an organization file
+func isOrgFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// hidden reports whether name is a dot file or directory, including the
+// ..data and ..<timestamp> directories Kubernetes creates in ConfigMap volumes
+func hidden(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// expandRepoFiles turns the --repo entries (files, directories and glob
+// patterns) into the list of organization files. Directories are walked
+// recursively in lexical order loading every *.yaml and *.yml file, skipping
+// hidden files and directories. Patterns do not match hidden files unless
+// their last element starts with a dot. Symlinked files are followed, symlinked
+// directories are not. Files listed more than once are loaded once.
+func expandRepoFiles(repo []string) (files []string, err error) {
+	add := func(f string) {
+		f = filepath.Clean(f)
+		if !slices.Contains(files, f) {
+			files = append(files, f)
+		}
+	}
+	for _, r := range repo {
+		paths := []string{r}
+		if isGlob(r) {
+			paths, err = filepath.Glob(r)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %s: %w", r, err)
+			}
+			// as in the shell, * does not match hidden files
+			if !hidden(filepath.Base(r)) {
+				paths = slices.DeleteFunc(paths, func(p string) bool { return hidden(filepath.Base(p)) })
+			}
+			if len(paths) == 0 {
+				return nil, fmt.Errorf("no organization file matches %s", r)
+			}
+			slices.Sort(paths)
+		}
+		for _, p := range paths {
+			info, err := os.Stat(p)
+			if err != nil {
+				return nil, fmt.Errorf("error while reading organization file: %w", err)
+			}
+			if !info.IsDir() {
+				add(p)
+				continue
+			}
+			found, err := walkOrgDir(p)
+			if err != nil {
+				return nil, err
+			}
+			if len(found) == 0 && !isGlob(r) {
+				return nil, fmt.Errorf("no organization file found in directory %s", p)
+			}
+			for _, f := range found {
+				add(f)
+			}
+		}
+	}
+	return
+}
+
+func walkOrgDir(dir string) (files []string, err error) {
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("error while reading organization directory: %w", err)
+		}
+		if path == dir {
+			return nil
+		}
+		if hidden(d.Name()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			info, err := os.Stat(path)
+			if err != nil {
+				return fmt.Errorf("error while reading organization file: %w", err)
+			}
+			if info.IsDir() {
+				return nil
+			}
+		} else if d.IsDir() {
+			return nil
+		}
+		if isOrgFile(d.Name()) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return
+}
+
+// renderOrganizations writes every organization file with its variables
+// expanded, each one preceded by a comment with its path
+func renderOrganizations(w io.Writer, repo []string) error {
+	values, err := vars.Load(valuesFiles...)
+	if err != nil {
+		return err
+	}
+	files, err := expandRepoFiles(repo)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, f := range files {
+		data, err := readOrganizationFile(f, values)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		fmt.Fprintf(w, "---\n# Source: %s\n%s", f, data)
+		if len(data) > 0 && data[len(data)-1] != '\n' {
+			fmt.Fprintln(w)
+		}
+	}
+	return errors.Join(errs...)
+}
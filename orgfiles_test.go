@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// orgTree creates the files of paths (directories end with /) under a temporary directory
+func orgTree(t *testing.T, paths ...string) string {
+	t.Helper()
+	root := t.TempDir()
+	for _, p := range paths {
+		full := filepath.Join(root, p)
+		if strings.HasSuffix(p, "/") {
+			if err := os.MkdirAll(full, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte("quay_organization: "+filepath.Base(p)+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestExpandRepoFiles(t *testing.T) {
+	root := orgTree(t,
+		"orgs/b.yaml",
+		"orgs/a.yml",
+		"orgs/README.md",
+		"orgs/team/c.YAML",
+		"orgs/.hidden.yaml",
+		"orgs/..data/d.yaml",
+		"orgs/empty/",
+		"single.yaml",
+	)
+	if err := os.Symlink(filepath.Join(root, "single.yaml"), filepath.Join(root, "orgs", "link.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "orgs", "team"), filepath.Join(root, "orgs", "linkdir")); err != nil {
+		t.Fatal(err)
+	}
+	rel := func(files []string) (out []string) {
+		for _, f := range files {
+			r, _ := filepath.Rel(root, f)
+			out = append(out, filepath.ToSlash(r))
+		}
+		return
+	}
+	in := func(paths ...string) (out []string) {
+		for _, p := range paths {
+			out = append(out, filepath.Join(root, p))
+		}
+		return
+	}
+
+	tests := []struct {
+		name string
+		repo []string
+		want []string
+		err  string
+	}{
+		{"file", in("single.yaml"), []string{"single.yaml"}, ""},
+		{"directory walked in lexical order", in("orgs"), []string{"orgs/a.yml", "orgs/b.yaml", "orgs/link.yaml", "orgs/team/c.YAML"}, ""},
+		{"glob", in("orgs/*.yaml"), []string{"orgs/b.yaml", "orgs/link.yaml"}, ""},
+		{"glob of a volume skips ..data", in("orgs/*"), []string{"orgs/README.md", "orgs/a.yml", "orgs/b.yaml", "orgs/link.yaml", "orgs/team/c.YAML"}, ""},
+		{"hidden glob", in("orgs/.*.yaml"), []string{"orgs/.hidden.yaml"}, ""},
+		{"duplicates loaded once", in("orgs/b.yaml", "orgs", "orgs/./b.yaml"), []string{"orgs/b.yaml", "orgs/a.yml", "orgs/link.yaml", "orgs/team/c.YAML"}, ""},
+		{"glob without match", in("orgs/*.json"), nil, "no organization file matches"},
+		{"empty directory", in("orgs/empty"), nil, "no organization file found in directory"},
+		{"empty directory matched by a glob", in("orgs/empt*"), nil, ""},
+		{"missing file", in("missing.yaml"), nil, "error while reading organization file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := expandRepoFiles(tt.repo)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := rel(files); !slices.Equal(got, tt.want) {
+				t.Errorf("files = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
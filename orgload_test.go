@@ -0,0 +1,55 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"repliquay/repliquay/internal/vars"
+)
+
+func TestReadOrganizations(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+		err     string
+	}{
+		{"single organization", "quay_organization: devops\n", []string{"devops"}, ""},
+		{"documents", "quay_organization: devops\n---\nquay_organization: sandbox\n", []string{"devops", "sandbox"}, ""},
+		{"list", "- quay_organization: devops\n- quay_organization: sandbox\n", []string{"devops", "sandbox"}, ""},
+		{"list and document", "- quay_organization: a\n- quay_organization: b\n---\nquay_organization: c\n", []string{"a", "b", "c"}, ""},
+		{"empty documents", "---\nquay_organization: devops\n---\n---\n", []string{"devops"}, ""},
+		{"empty file", "", nil, ""},
+		{"comments only", "# no organization yet\n", nil, ""},
+		{"malformed second document", "quay_organization: devops\n---\nquay_organization: [sandbox\n", nil, "yaml"},
+		{"scalar second document", "quay_organization: devops\n---\njust text\n", nil, "document 2: expected an organization or a list of organizations"},
+		{"mistyped second document", "quay_organization: devops\n---\nquay_organization: sandbox\nrobots: ci\n", nil, "document 2"},
+		{"mistyped list item", "- quay_organization: a\n- robots: ci\n", nil, "document 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := writeFile(t, "org.yaml", tt.content)
+			orgs, err := readOrganizations(file, vars.Values{})
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) || !strings.Contains(err.Error(), file) {
+					t.Fatalf("error = %v, want %q naming %s", err, tt.err, file)
+				}
+				if orgs != nil {
+					t.Errorf("organizations = %v, want none on error", orgs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, o := range orgs {
+				names = append(names, o.Name)
+			}
+			if !slices.Equal(names, tt.want) {
+				t.Errorf("organizations = %q, want %q", names, tt.want)
+			}
+		})
+	}
+}
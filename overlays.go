@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Overlay marks an organization document as a patch of the organization with
+// the same name, applied to the hosts whose labels match Selector
+type Overlay struct {
+	Selector string `yaml:"selector"`
+	selector map[string]string
+}
+
+// orgSet holds the organizations read from the organization files
+type orgSet struct {
+	orgs     []Organization
+	overlays []Organization
+	// permission profiles shared by all the organizations
+	profiles map[string]RepoPermissionStruct
+	// organization -> file defining it
+	files map[string]string
+}
+
+// forHost returns the organizations targeting host as seen by it.
+// applied lists the selectors of the overlays applied to each organization.
+func (s *orgSet) forHost(host HostToken) (orgs []Organization, applied map[string][]string, err error) {
+	applied = make(map[string][]string)
+	for _, base := range s.orgs {
+		if !base.targetsHost(host) {
+			continue
+		}
+		org, overlays, err := s.effective(base, host)
+		if err != nil {
+			return nil, nil, err
+		}
+		applied[org.Name] = overlays
+		orgs = append(orgs, org)
+	}
+	return
+}
+
+// effective applies to base every overlay matching the host labels in file
+// order, then resolves profiles and rules
+func (s *orgSet) effective(base Organization, host HostToken) (org Organization, applied []string, err error) {
+	org = base
+	org.Profiles = maps.Clone(base.Profiles)
+	org.Rules = slices.Clone(base.Rules)
+	// shared profiles are copied first, so that overlays removing a robot or a team patch them too
+	for name, p := range s.profiles {
+		if _, ok := org.Profiles[name]; !ok {
+			if org.Profiles == nil {
+				org.Profiles = make(map[string]RepoPermissionStruct)
+			}
+			org.Profiles[name] = p
+		}
+	}
+	for _, ov := range s.overlays {
+		if ov.Name != org.Name || !matchLabels(ov.Overlay.selector, host.Labels) {
+			continue
+		}
+		org = applyOverlay(org, ov)
+		applied = append(applied, ov.Overlay.Selector)
+	}
+	prefix := ""
+	if host.Host != "" {
+		prefix = host.Host + ": "
+	}
+	if err := org.compileRules(); err != nil {
+		return org, nil, fmt.Errorf("%s%w (organization defined in %s)", prefix, err, s.files[org.Name])
+	}
+	if err := org.resolveProfiles(s.profiles); err != nil {
+		return org, nil, fmt.Errorf("%s%w (organization defined in %s)", prefix, err, s.files[org.Name])
+	}
+	if err := org.checkRemovals(); err != nil {
+		return org, nil, fmt.Errorf("%s%w (organization defined in %s)", prefix, err, s.files[org.Name])
+	}
+	return org, applied, nil
+}
+
+// applyOverlay patches org with ov. Robots, teams and repositories are matched
+// by name: overlay items replace the existing ones (repositories merge their
+// permissions and profile), are appended when new and deleted with remove: true.
+// Repository permissions removed by the overlay are also removed from the
+// permissions of the repository profile and rules. Removing a robot or a team
+// also drops its repository permissions.
+// Profiles are replaced by name and permission rules appended.
+func applyOverlay(org Organization, ov Organization) Organization {
+	if ov.OrgRoleName != "" {
+		org.OrgRoleName = ov.OrgRoleName
+	}
+	if ov.DefaultProfile != "" {
+		org.DefaultProfile = ov.DefaultProfile
+	}
+	if len(ov.Profiles) > 0 && org.Profiles == nil {
+		org.Profiles = make(map[string]RepoPermissionStruct)
+	}
+	maps.Copy(org.Profiles, ov.Profiles)
+	org.Rules = append(org.Rules, ov.Rules...)
+
+	org.RobotList = patchByName(org.RobotList, ov.RobotList,
+		func(v RobotStruct) string { return v.Name },
+		func(v RobotStruct) bool { return v.Remove },
+		func(_ RobotStruct, v RobotStruct) RobotStruct { return v })
+	org.TeamsList = patchByName(org.TeamsList, ov.TeamsList,
+		func(v TeamStruct) string { return v.Name },
+		func(v TeamStruct) bool { return v.Remove },
+		func(_ TeamStruct, v TeamStruct) TeamStruct { return v })
+	org.RepoList = patchByName(org.RepoList, ov.RepoList,
+		func(v RepoStruct) string { return v.Name },
+		func(v RepoStruct) bool { return v.Remove },
+		func(old RepoStruct, v RepoStruct) RepoStruct {
+			if v.Profile != "" {
+				old.Profile = v.Profile
+			}
+			old.PermissionList = patchRepoPermissions(old.PermissionList, v.PermissionList)
+			return old
+		})
+
+	// permissions of removed robots and teams
+	var drop RepoPermissionStruct
+	for _, v := range ov.RobotList {
+		if v.Remove {
+			drop.Robots = append(drop.Robots, PermStruct{Name: v.Name, Remove: true})
+		}
+	}
+	for _, v := range ov.TeamsList {
+		if v.Remove {
+			drop.Teams = append(drop.Teams, PermStruct{Name: v.Name, Remove: true})
+		}
+	}
+	if len(drop.Robots) > 0 || len(drop.Teams) > 0 {
+		for i := range org.RepoList {
+			org.RepoList[i].PermissionList = patchPermissions(org.RepoList[i].PermissionList, drop)
+		}
+		for k, v := range org.Profiles {
+			org.Profiles[k] = patchPermissions(v, drop)
+		}
+		for i := range org.Rules {
+			org.Rules[i].Robots = patchPermissions(RepoPermissionStruct{Robots: org.Rules[i].Robots}, drop).Robots
+			org.Rules[i].Teams = patchPermissions(RepoPermissionStruct{Teams: org.Rules[i].Teams}, drop).Teams
+		}
+	}
+	return org
+}
+
+func patchPermissions(base RepoPermissionStruct, patch RepoPermissionStruct) RepoPermissionStruct {
+	name := func(v PermStruct) string { return v.Name }
+	remove := func(v PermStruct) bool { return v.Remove }
+	replace := func(_ PermStruct, v PermStruct) PermStruct { return v }
+	return RepoPermissionStruct{
+		Robots: patchByName(base.Robots, patch.Robots, name, remove, replace),
+		Teams:  patchByName(base.Teams, patch.Teams, name, remove, replace),
+	}
+}
+
+// patchRepoPermissions patches the permissions of a repository. The removals
+// not matching a permission of the repository are kept, to be applied to the
+// permissions of its profile and rules once expanded.
+func patchRepoPermissions(base RepoPermissionStruct, patch RepoPermissionStruct) RepoPermissionStruct {
+	perms := patchPermissions(base, patch)
+	perms.Robots = append(perms.Robots, unmatchedRemovals(base.Robots, patch.Robots)...)
+	perms.Teams = append(perms.Teams, unmatchedRemovals(base.Teams, patch.Teams)...)
+	return perms
+}
+
+// unmatchedRemovals returns the removals of patch without a permission in base
+func unmatchedRemovals(base []PermStruct, patch []PermStruct) (removals []PermStruct) {
+	for _, p := range patch {
+		if p.Remove && !slices.ContainsFunc(base, func(v PermStruct) bool { return v.Name == p.Name && !v.Remove }) {
+			removals = append(removals, p)
+		}
+	}
+	return
+}
+
+// patchByName returns a copy of base with the items of patch merged, appended or removed
+func patchByName[T any](base []T, patch []T, name func(T) string, remove func(T) bool, merge func(T, T) T) []T {
+	items := slices.Clone(base)
+	for _, p := range patch {
+		i := slices.IndexFunc(items, func(v T) bool { return name(v) == name(p) })
+		switch {
+		case remove(p) && i >= 0:
+			items = slices.Delete(items, i, i+1)
+		case remove(p):
+		case i >= 0:
+			items[i] = merge(items[i], p)
+		default:
+			items = append(items, p)
+		}
+	}
+	return items
+}
+
+// printHostOrganizations prints the organizations applied to a host. In dry
+// run mode the effective configuration of the organizations patched by an
+// overlay is printed as well.
+func printHostOrganizations(host HostToken, orgs []Organization, applied map[string][]string) {
+	for _, o := range orgs {
+		overlays := "none"
+		if len(applied[o.Name]) > 0 {
+			overlays = strings.Join(applied[o.Name], "; ")
+		}
+		fmt.Printf("Host %s organization %s: %d robots, %d teams, %d repositories, overlays: %s\n", host.Host, o.Name, len(o.RobotList), len(o.TeamsList), len(o.RepoList), overlays)
+		if dryRun && len(applied[o.Name]) > 0 {
+			o.Overlay = nil
+			out, err := yaml.Marshal(o)
+			if err != nil {
+				fmt.Printf("Unable to print organization %s: %s\n", o.Name, err)
+				continue
+			}
+			fmt.Printf("--- # effective configuration of organization %s on host %s\n%s", o.Name, host.Host, out)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+)
+
+type item struct {
+	name   string
+	value  int
+	remove bool
+}
+
+func TestPatchByName(t *testing.T) {
+	base := []item{{name: "a", value: 1}, {name: "b", value: 2}}
+	tests := []struct {
+		name  string
+		patch []item
+		want  []item
+	}{
+		{"empty patch", nil, base},
+		{"merge", []item{{name: "b", value: 20}}, []item{{name: "a", value: 1}, {name: "b", value: 22}}},
+		{"append", []item{{name: "c", value: 3}}, []item{{name: "a", value: 1}, {name: "b", value: 2}, {name: "c", value: 3}}},
+		{"remove", []item{{name: "a", remove: true}}, []item{{name: "b", value: 2}}},
+		{"remove missing", []item{{name: "z", remove: true}}, base},
+		{"remove then append", []item{{name: "a", remove: true}, {name: "a", value: 5}}, []item{{name: "b", value: 2}, {name: "a", value: 5}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orig := slices.Clone(base)
+			got := patchByName(base, tt.patch,
+				func(v item) string { return v.name },
+				func(v item) bool { return v.remove },
+				func(old item, v item) item { old.value += v.value; return old })
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("patchByName = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(base, orig) {
+				t.Errorf("base modified: %v", base)
+			}
+		})
+	}
+}
+
+func TestApplyOverlay(t *testing.T) {
+	org := Organization{
+		Name:      "devops",
+		RobotList: []RobotStruct{{Name: "ci"}, {Name: "deploy"}},
+		TeamsList: []TeamStruct{{Name: "devs", Role: "member"}},
+		RepoList: []RepoStruct{
+			{Name: "app", Profile: "default", PermissionList: RepoPermissionStruct{
+				Robots: []PermStruct{{Name: "ci", Role: "write"}, {Name: "deploy", Role: "read"}},
+				Teams:  []PermStruct{{Name: "devs", Role: "read"}},
+			}},
+			{Name: "lib"},
+		},
+		Profiles: map[string]RepoPermissionStruct{
+			"default": {Robots: []PermStruct{{Name: "deploy", Role: "read"}}},
+		},
+		Rules: []PermissionRule{{Repos: "app*", Robots: []PermStruct{{Name: "deploy", Role: "write"}}}},
+	}
+	ov := Organization{
+		OrgRoleName: "admin",
+		RobotList:   []RobotStruct{{Name: "deploy", Remove: true}, {Name: "dr"}},
+		TeamsList:   []TeamStruct{{Name: "devs", Role: "creator"}},
+		RepoList: []RepoStruct{
+			{Name: "app", PermissionList: RepoPermissionStruct{
+				Robots: []PermStruct{{Name: "ci", Role: "read"}, {Name: "dr", Role: "write"}},
+				Teams:  []PermStruct{{Name: "owners", Remove: true}},
+			}},
+			{Name: "lib", Remove: true},
+			{Name: "dr-only"},
+		},
+	}
+	got := applyOverlay(org, ov)
+
+	if got.OrgRoleName != "admin" {
+		t.Errorf("role = %q, want admin", got.OrgRoleName)
+	}
+	if want := []RobotStruct{{Name: "ci"}, {Name: "dr"}}; !reflect.DeepEqual(got.RobotList, want) {
+		t.Errorf("robots = %v, want %v", got.RobotList, want)
+	}
+	if got.TeamsList[0].Role != "creator" {
+		t.Errorf("team devs role = %q, want creator", got.TeamsList[0].Role)
+	}
+	var repos []string
+	for _, r := range got.RepoList {
+		repos = append(repos, r.Name)
+	}
+	if want := []string{"app", "dr-only"}; !slices.Equal(repos, want) {
+		t.Fatalf("repositories = %q, want %q", repos, want)
+	}
+	app := got.RepoList[0]
+	if app.Profile != "default" {
+		t.Errorf("app profile = %q, want default kept", app.Profile)
+	}
+	wantApp := RepoPermissionStruct{
+		Robots: []PermStruct{{Name: "ci", Role: "read"}, {Name: "dr", Role: "write"}},
+		Teams:  []PermStruct{{Name: "devs", Role: "read"}, {Name: "owners", Remove: true}},
+	}
+	if !reflect.DeepEqual(app.PermissionList, wantApp) {
+		t.Errorf("app permissions = %+v, want %+v", app.PermissionList, wantApp)
+	}
+	// the removed robot is dropped from the profiles and the rules
+	if p := got.Profiles["default"]; len(p.Robots) != 0 {
+		t.Errorf("default profile robots = %v, want none", p.Robots)
+	}
+	if r := got.Rules[0]; len(r.Robots) != 0 {
+		t.Errorf("rule robots = %v, want none", r.Robots)
+	}
+	// org is not modified
+	if len(org.RobotList) != 2 || len(org.RepoList) != 2 || len(org.RepoList[0].PermissionList.Robots) != 2 {
+		t.Errorf("base organization modified: %+v", org)
+	}
+}
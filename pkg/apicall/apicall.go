@@ -0,0 +1,397 @@
+package apicall
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultMaxConnections is used when Max_connections is not set
+const DefaultMaxConnections = 5
+
+const (
+	DefaultDialTimeout     = 10 * time.Second
+	DefaultTLSTimeout      = 10 * time.Second
+	DefaultResponseTimeout = 60 * time.Second
+	DefaultRequestTimeout  = 120 * time.Second
+	idleConnTimeout        = 90 * time.Second
+)
+
+// Timeouts of a host connection. Zero values fall back to the Default* timeouts.
+type Timeouts struct {
+	Dial     time.Duration // TCP connection establishment
+	TLS      time.Duration // TLS handshake
+	Response time.Duration // wait for response headers after the request is sent
+	Request  time.Duration // whole request including reading the body
+}
+
+// HostConnection limits the concurrent api calls to a host to Max_connections.
+// Callers over the limit block on a semaphore until a connection is released.
+type HostConnection struct {
+	Hostname                            string
+	TLSConfig                           *tls.Config
+	Debug, SkipVerify, DryRun, Insecure bool
+	SleepPeriod, Retries                int
+	RetryPolicy                         RetryPolicy
+	Max_connections                     int
+	Timeouts                            Timeouts
+	TotalApiCall                        int
+	Mx                                  sync.Mutex
+	semOnce                             sync.Once
+	sem                                 chan struct{}
+	inFlight, queued                    int
+	// requests answered by the host, updated once the response is read
+	completed  atomic.Int64
+	clientOnce sync.Once
+	client     *http.Client
+	// OnRequest, when set, is called after every request sent to the host
+	OnRequest func(RequestInfo)
+	// Capabilities of the Quay instance, set once detected
+	Capabilities Capabilities
+	// DryRunReads still sends the GET requests in dry run mode, so that the
+	// changes a run would make can be computed against the host
+	DryRunReads bool
+	// Output receives the progress messages, os.Stdout when nil
+	Output io.Writer
+}
+
+// Capabilities are the Quay release and features of a host
+type Capabilities struct {
+	// Detected is false until the capabilities have been read from the host
+	Detected bool
+	Version  string
+	// Features supported by the host and enabled in its configuration
+	Features map[string]bool
+	// Unsupported are the features the declared release of the host does not
+	// provide, known even when the capabilities are not detected
+	Unsupported map[string]bool
+}
+
+// Supports reports whether the host supports feature. Every feature but the
+// ones its release does not provide is assumed supported by hosts whose
+// capabilities are unknown.
+func (c Capabilities) Supports(feature string) bool {
+	if c.Unsupported[feature] {
+		return false
+	}
+	return !c.Detected || c.Features[feature]
+}
+
+// RequestInfo describes a single attempt of an api call
+type RequestInfo struct {
+	Host       string
+	Method     string
+	StatusCode int
+	Err        error
+	Attempt    int
+	Duration   time.Duration
+}
+
+func (hc *HostConnection) SetGlobalVars(debug bool, skipverify bool, dryrun bool, insecure bool, sleepPeriod int, retries int) {
+	hc.Debug = debug
+	hc.SkipVerify = skipverify
+	hc.DryRun = dryrun
+	hc.Insecure = insecure
+	hc.SleepPeriod = sleepPeriod
+	hc.Retries = retries
+}
+
+// TLSOptions are the per host TLS settings
+type TLSOptions struct {
+	CAFile     string
+	CertFile   string
+	KeyFile    string
+	ServerName string
+	SkipVerify bool
+}
+
+// SetTLS overrides the global insecure/skipVerify settings for this host.
+// scheme can be "http", "https" or empty to keep the global setting.
+func (hc *HostConnection) SetTLS(scheme string, opts TLSOptions) error {
+	switch scheme {
+	case "":
+	case "http":
+		hc.Insecure = true
+	case "https":
+		hc.Insecure = false
+	default:
+		return fmt.Errorf("%s: unsupported scheme %q", hc.Hostname, scheme)
+	}
+	hc.SkipVerify = opts.SkipVerify
+
+	cfg := &tls.Config{InsecureSkipVerify: opts.SkipVerify, ServerName: opts.ServerName}
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return fmt.Errorf("%s: unable to read CA bundle: %w", hc.Hostname, err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%s: no certificate found in CA bundle %s", hc.Hostname, opts.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		if opts.CertFile == "" || opts.KeyFile == "" {
+			return fmt.Errorf("%s: client certificate requires both cert_file and key_file", hc.Hostname)
+		}
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return fmt.Errorf("%s: unable to load client certificate: %w", hc.Hostname, err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	hc.TLSConfig = cfg
+	return nil
+}
+
+func (hc *HostConnection) tlsConfig() *tls.Config {
+	if hc.TLSConfig != nil {
+		return hc.TLSConfig
+	}
+	return &tls.Config{InsecureSkipVerify: hc.SkipVerify}
+}
+
+// httpClient returns the pooled client shared by all the calls to this host,
+// it must not be called before SetTLS
+func (hc *HostConnection) httpClient() *http.Client {
+	hc.clientOnce.Do(func() {
+		t := hc.Timeouts
+		t.Dial = durationOr(t.Dial, DefaultDialTimeout)
+		t.TLS = durationOr(t.TLS, DefaultTLSTimeout)
+		t.Response = durationOr(t.Response, DefaultResponseTimeout)
+		t.Request = durationOr(t.Request, DefaultRequestTimeout)
+		maxConn := hc.MaxConnections()
+
+		tr := &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           (&net.Dialer{Timeout: t.Dial, KeepAlive: 30 * time.Second}).DialContext,
+			TLSClientConfig:       hc.tlsConfig(),
+			TLSHandshakeTimeout:   t.TLS,
+			ResponseHeaderTimeout: t.Response,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          maxConn,
+			MaxIdleConnsPerHost:   maxConn,
+			MaxConnsPerHost:       maxConn,
+			IdleConnTimeout:       idleConnTimeout,
+		}
+		hc.client = &http.Client{Transport: tr, Timeout: t.Request}
+	})
+	return hc.client
+}
+
+// Close releases the idle connections kept by the pooled client
+func (hc *HostConnection) Close() {
+	if hc.client != nil {
+		hc.client.CloseIdleConnections()
+	}
+}
+
+func durationOr(d time.Duration, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d
+}
+
+func (hc *HostConnection) baseUrl(host string) string {
+	if hc.Insecure {
+		return "http://" + host
+	}
+	return "https://" + host
+}
+
+// printf prints a progress message on hc.Output
+func (hc *HostConnection) printf(format string, a ...any) {
+	out := hc.Output
+	if out == nil {
+		out = os.Stdout
+	}
+	fmt.Fprintf(out, format, a...)
+}
+
+// MaxConnections returns the effective concurrent connections limit
+func (hc *HostConnection) MaxConnections() int {
+	if hc.Max_connections < 1 {
+		return DefaultMaxConnections
+	}
+	return hc.Max_connections
+}
+
+// Stats returns the in flight and queued api calls and the number of requests
+// the host answered
+func (hc *HostConnection) Stats() (inFlight int, queued int, completed int) {
+	hc.Mx.Lock()
+	defer hc.Mx.Unlock()
+	return hc.inFlight, hc.queued, int(hc.completed.Load())
+}
+
+// acquire blocks until a connection slot is available or ctx is done
+func (hc *HostConnection) acquire(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	hc.semOnce.Do(func() {
+		hc.sem = make(chan struct{}, hc.MaxConnections())
+	})
+	hc.Mx.Lock()
+	hc.queued++
+	hc.Mx.Unlock()
+
+	select {
+	case hc.sem <- struct{}{}:
+	case <-ctx.Done():
+		hc.Mx.Lock()
+		hc.queued--
+		hc.Mx.Unlock()
+		return ctx.Err()
+	}
+
+	hc.Mx.Lock()
+	defer hc.Mx.Unlock()
+	hc.queued--
+	hc.inFlight++
+	hc.TotalApiCall++
+	return nil
+}
+
+func (hc *HostConnection) release() {
+	hc.Mx.Lock()
+	hc.inFlight--
+	hc.Mx.Unlock()
+	<-hc.sem
+}
+
+// Skips reports whether requests with method are not sent: in dry run mode
+// every request, or only the writes with DryRunReads
+func (hc *HostConnection) Skips(method string) bool {
+	return hc.DryRun && (!hc.DryRunReads || method != http.MethodGet)
+}
+
+// ApiCall performs the api call, retrying it according to hc.RetryPolicy.
+// Every call keeps its own attempts counter. Once ctx is done no new attempt
+// is started, while a request already sent is left to complete.
+// attempts is the number of requests actually sent.
+func (hc *HostConnection) ApiCall(ctx context.Context, host string, url string, method string, token string, bodyData string, action string) (httpCode int, responseBody string, attempts int, err error) {
+	if hc.Skips(method) {
+		if hc.Debug {
+			hc.printf("%s: dry run %s %s action %s\n", hc.Hostname, method, url, action)
+		}
+		return
+	}
+
+	for attempt := 0; ; attempt++ {
+		var retryAfter time.Duration
+		httpCode, responseBody, retryAfter, err = hc.doRequest(ctx, attempt+1, host, url, method, token, bodyData, action)
+		if ctx.Err() != nil && err == ctx.Err() {
+			return
+		}
+		attempts = attempt + 1
+		if !hc.RetryPolicy.retryable(httpCode, err) {
+			break
+		}
+		if err != nil {
+			log.Printf("%s Request failed: %s\nRequest data %s url %s method %s", host, err, bodyData, url, method)
+		} else {
+			log.Printf("%s Response failed with status code: %d and\nbody: %s\nRequest data %s url %s method %s", host, httpCode, responseBody, bodyData, url, method)
+		}
+		if attempt >= hc.Retries {
+			log.Printf("Too many attempts: unable to execute action %s with requested data %s on host %s successfully\n", action, bodyData, host)
+			if err == nil {
+				err = fmt.Errorf("%s: %s failed after %d attempts with status code %d", host, action, attempt+1, httpCode)
+			} else {
+				err = fmt.Errorf("%s: %s failed after %d attempts: %w", host, action, attempt+1, err)
+			}
+			return
+		}
+		delay, ok := hc.RetryPolicy.backoff(attempt, retryAfter)
+		if !ok {
+			log.Printf("Retry-After %s over the max delay: unable to execute action %s with requested data %s on host %s successfully\n", delay, action, bodyData, host)
+			err = fmt.Errorf("%s: %s failed with status code %d, Retry-After %s exceeds the max delay between retries", host, action, httpCode, delay)
+			return
+		}
+		log.Printf("Sleeping %s before attempt %d/%d on %s %s %s\n", delay, attempt+2, hc.Retries+1, host, bodyData, action)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("%s: %s: %w", host, action, err)
+		return
+	}
+	if hc.Debug {
+		log.Printf("%s Action %s completed\n", host, action)
+		inFlight, queued, _ := hc.Stats()
+		hc.printf("%s: in flight %d queued %d action %s\n", hc.Hostname, inFlight, queued, action)
+	}
+	return
+}
+
+// doRequest performs a single attempt holding a connection slot
+func (hc *HostConnection) doRequest(ctx context.Context, attempt int, host string, url string, method string, token string, bodyData string, action string) (httpCode int, responseBody string, retryAfter time.Duration, err error) {
+	if err = hc.acquire(ctx); err != nil {
+		return
+	}
+	defer hc.release()
+	if hc.Debug {
+		inFlight, queued, _ := hc.Stats()
+		hc.printf("%s: in flight %d/%d queued %d action %s\n", hc.Hostname, inFlight, hc.MaxConnections(), queued, action)
+	}
+
+	var body io.Reader
+	if bodyData != "" {
+		body = strings.NewReader(bodyData)
+	}
+	// cancellation only stops new calls: requests already sent are drained,
+	// bounded by the request timeout
+	req, err := http.NewRequestWithContext(context.WithoutCancel(ctx), method, hc.baseUrl(host)+url, body)
+	if err != nil {
+		return
+	}
+	if token != "" {
+		req.Header.Add("Authorization", "Bearer "+token)
+		req.Header.Add("Content-Type", "application/json")
+	}
+
+	start := time.Now()
+	res, err := hc.httpClient().Do(req)
+	if hc.OnRequest != nil {
+		defer func() {
+			hc.OnRequest(RequestInfo{Host: hc.Hostname, Method: method, StatusCode: httpCode, Err: err, Attempt: attempt, Duration: time.Since(start)})
+		}()
+	}
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+	res_body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return
+	}
+
+	// every count is reached by a single request, printing it once
+	if completed := hc.completed.Add(1); completed%10 == 0 {
+		inFlight, queued, _ := hc.Stats()
+		hc.printf("Host %s: completed %d Api Call (in flight %d, queued %d)\n", hc.Hostname, completed, inFlight, queued)
+	}
+	httpCode = res.StatusCode
+	responseBody = string(res_body)
+	retryAfter = parseRetryAfter(res.Header.Get("Retry-After"))
+	return
+}
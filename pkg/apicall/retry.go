@@ -0,0 +1,115 @@
+package apicall
+
+import (
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Transport error kinds that can be listed in RetryPolicy.TransportErrors
+const (
+	ErrTimeout     = "timeout"
+	ErrConnRefused = "connrefused"
+	ErrConnReset   = "connreset"
+	ErrEOF         = "eof"
+	ErrDNS         = "dns"
+)
+
+var (
+	DefaultRetryStatusCodes = []int{http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+	DefaultTransportErrors  = []string{ErrTimeout, ErrConnRefused, ErrConnReset, ErrEOF}
+)
+
+const (
+	DefaultBaseDelay = 500 * time.Millisecond
+	DefaultMaxDelay  = 30 * time.Second
+)
+
+// RetryPolicy defines which failures are retried and how long to wait between attempts.
+// Zero values fall back to the Default* settings.
+type RetryPolicy struct {
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	StatusCodes     []int
+	TransportErrors []string
+}
+
+func (p RetryPolicy) retryable(httpCode int, err error) bool {
+	if err != nil {
+		kinds := p.TransportErrors
+		if kinds == nil {
+			kinds = DefaultTransportErrors
+		}
+		return slices.Contains(kinds, TransportErrorKind(err))
+	}
+	codes := p.StatusCodes
+	if codes == nil {
+		codes = DefaultRetryStatusCodes
+	}
+	return slices.Contains(codes, httpCode)
+}
+
+// backoff returns an exponential delay with jitter for the given attempt (0 based).
+// A server provided Retry-After is honoured when longer, ok is false when it
+// exceeds MaxDelay: the call gives up instead of stalling its worker.
+func (p RetryPolicy) backoff(attempt int, retryAfter time.Duration) (delay time.Duration, ok bool) {
+	base, max := p.BaseDelay, p.MaxDelay
+	if base <= 0 {
+		base = DefaultBaseDelay
+	}
+	if max <= 0 {
+		max = DefaultMaxDelay
+	}
+	if retryAfter > max {
+		return retryAfter, false
+	}
+	delay = max
+	if attempt < 32 && base<<attempt > 0 && base<<attempt < max {
+		delay = base << attempt
+	}
+	// equal jitter: half fixed, half random
+	delay = delay/2 + rand.N(delay/2+1)
+	if retryAfter > delay {
+		return retryAfter, true
+	}
+	return delay, true
+}
+
+// TransportErrorKind classifies a request error, returning "other" for unknown errors
+func TransportErrorKind(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrConnRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return ErrConnReset
+	case errors.As(err, &dnsErr):
+		return ErrDNS
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ErrTimeout
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return ErrEOF
+	}
+	return "other"
+}
+
+// parseRetryAfter accepts both delay seconds and HTTP date values
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && time.Until(t) > 0 {
+		return time.Until(t)
+	}
+	return 0
+}
@@ -0,0 +1,192 @@
+package apicall
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryable(t *testing.T) {
+	custom := RetryPolicy{StatusCodes: []int{http.StatusConflict}, TransportErrors: []string{ErrDNS}}
+	tests := []struct {
+		name   string
+		policy RetryPolicy
+		code   int
+		err    error
+		want   bool
+	}{
+		{"default 503", RetryPolicy{}, http.StatusServiceUnavailable, nil, true},
+		{"default 429", RetryPolicy{}, http.StatusTooManyRequests, nil, true},
+		{"default 404", RetryPolicy{}, http.StatusNotFound, nil, false},
+		{"default 200", RetryPolicy{}, http.StatusOK, nil, false},
+		{"default connection refused", RetryPolicy{}, 0, fmt.Errorf("dial: %w", syscall.ECONNREFUSED), true},
+		{"default eof", RetryPolicy{}, 0, io.ErrUnexpectedEOF, true},
+		{"default other error", RetryPolicy{}, 0, errors.New("malformed"), false},
+		{"custom 409", custom, http.StatusConflict, nil, true},
+		{"custom 503", custom, http.StatusServiceUnavailable, nil, false},
+		{"custom connection refused", custom, 0, syscall.ECONNREFUSED, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.retryable(tt.code, tt.err); got != tt.want {
+				t.Errorf("retryable(%d, %v) = %t, want %t", tt.code, tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	tests := []struct {
+		attempt    int
+		retryAfter time.Duration
+		min, max   time.Duration
+		ok         bool
+	}{
+		{0, 0, 50 * time.Millisecond, 100 * time.Millisecond, true},
+		{1, 0, 100 * time.Millisecond, 200 * time.Millisecond, true},
+		{3, 0, 400 * time.Millisecond, 800 * time.Millisecond, true},
+		// capped to MaxDelay, including shifts overflowing
+		{4, 0, 500 * time.Millisecond, time.Second, true},
+		{40, 0, 500 * time.Millisecond, time.Second, true},
+		// a longer Retry-After wins up to MaxDelay, a shorter one is ignored
+		{0, 800 * time.Millisecond, 800 * time.Millisecond, 800 * time.Millisecond, true},
+		{0, time.Second, time.Second, time.Second, true},
+		{3, time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, true},
+		// a Retry-After over MaxDelay gives up
+		{0, 5 * time.Second, 5 * time.Second, 5 * time.Second, false},
+		{0, 24 * time.Hour, 24 * time.Hour, 24 * time.Hour, false},
+	}
+	for _, tt := range tests {
+		for range 50 {
+			if d, ok := p.backoff(tt.attempt, tt.retryAfter); d < tt.min || d > tt.max || ok != tt.ok {
+				t.Fatalf("backoff(%d, %s) = %s, %t, want %s to %s, %t", tt.attempt, tt.retryAfter, d, ok, tt.min, tt.max, tt.ok)
+			}
+		}
+	}
+	if d, _ := (RetryPolicy{}).backoff(0, 0); d < DefaultBaseDelay/2 || d > DefaultBaseDelay {
+		t.Errorf("default backoff(0) = %s, want %s to %s", d, DefaultBaseDelay/2, DefaultBaseDelay)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	for value, want := range map[string]time.Duration{
+		"":      0,
+		"3":     3 * time.Second,
+		"0":     0,
+		"-1":    0,
+		"soon":  0,
+		"1.5":   0,
+		"86400": 24 * time.Hour,
+	} {
+		if got := parseRetryAfter(value); got != want {
+			t.Errorf("parseRetryAfter(%q) = %s, want %s", value, got, want)
+		}
+	}
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(date); got < 58*time.Second || got > time.Minute {
+		t.Errorf("parseRetryAfter(%q) = %s, want about 1m", date, got)
+	}
+	past := time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(past); got != 0 {
+		t.Errorf("parseRetryAfter(%q) = %s, want 0", past, got)
+	}
+}
+
+// testConnection returns a connection to a server answering with the
+// status codes of codes in turn, the last one repeated
+func testConnection(t *testing.T, retries int, codes ...int) (*HostConnection, string, *atomic.Int32) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(requests.Add(1))
+		code := codes[min(n, len(codes))-1]
+		if code == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "1")
+		}
+		w.WriteHeader(code)
+		fmt.Fprintf(w, `{"request":%d}`, n)
+	}))
+	t.Cleanup(srv.Close)
+	hc := &HostConnection{
+		Hostname:    "quay.example.com",
+		Insecure:    true,
+		Retries:     retries,
+		RetryPolicy: RetryPolicy{BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond},
+		Output:      io.Discard,
+	}
+	t.Cleanup(hc.Close)
+	return hc, strings.TrimPrefix(srv.URL, "http://"), &requests
+}
+
+func TestApiCallRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		retries  int
+		codes    []int
+		code     int
+		attempts int
+		err      bool
+	}{
+		{"success", 3, []int{200}, 200, 1, false},
+		{"retried until success", 3, []int{503, 502, 200}, 200, 3, false},
+		{"not retried", 3, []int{404}, 404, 1, false},
+		{"retries exhausted", 2, []int{503}, 503, 3, true},
+		{"no retries", 0, []int{500}, 500, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hc, host, requests := testConnection(t, tt.retries, tt.codes...)
+			code, _, attempts, err := hc.ApiCall(context.Background(), host, "/api/v1/user/", http.MethodGet, "token", "", "get user")
+			if code != tt.code || attempts != tt.attempts || (err != nil) != tt.err {
+				t.Errorf("ApiCall = code %d attempts %d err %v, want code %d attempts %d error %t", code, attempts, err, tt.code, tt.attempts, tt.err)
+			}
+			if n := int(requests.Load()); n != tt.attempts {
+				t.Errorf("server received %d requests, want %d", n, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestApiCallHonoursRetryAfter(t *testing.T) {
+	hc, host, _ := testConnection(t, 1, http.StatusTooManyRequests, http.StatusOK)
+	hc.RetryPolicy.MaxDelay = 2 * time.Second
+	start := time.Now()
+	code, _, attempts, err := hc.ApiCall(context.Background(), host, "/api/v1/user/", http.MethodGet, "token", "", "get user")
+	if err != nil || code != http.StatusOK || attempts != 2 {
+		t.Fatalf("ApiCall = code %d attempts %d err %v, want 200 after 2 attempts", code, attempts, err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %s, want the 1s Retry-After", elapsed)
+	}
+}
+
+func TestApiCallRetryAfterOverMaxDelay(t *testing.T) {
+	hc, host, requests := testConnection(t, 3, http.StatusTooManyRequests, http.StatusOK)
+	start := time.Now()
+	code, _, attempts, err := hc.ApiCall(context.Background(), host, "/api/v1/user/", http.MethodGet, "token", "", "get user")
+	if err == nil || !strings.Contains(err.Error(), "Retry-After 1s exceeds the max delay") || code != http.StatusTooManyRequests || attempts != 1 || requests.Load() != 1 {
+		t.Errorf("ApiCall = code %d attempts %d err %v, want the 429 given up after 1 attempt", code, attempts, err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("gave up after %s, want no wait", elapsed)
+	}
+}
+
+func TestApiCallCancelledBackoff(t *testing.T) {
+	hc, host, requests := testConnection(t, 5, http.StatusServiceUnavailable)
+	hc.RetryPolicy = RetryPolicy{BaseDelay: time.Minute, MaxDelay: time.Minute}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, _, attempts, err := hc.ApiCall(ctx, host, "/api/v1/user/", http.MethodGet, "token", "", "get user")
+	if !errors.Is(err, context.DeadlineExceeded) || attempts != 1 || requests.Load() != 1 {
+		t.Errorf("ApiCall = attempts %d err %v, want the deadline during the first backoff", attempts, err)
+	}
+}
@@ -0,0 +1,137 @@
+package quay
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Object kinds of an Action
+const (
+	ObjectUser         = "user"
+	ObjectOrganization = "organization"
+	ObjectRobot        = "robot"
+	ObjectTeam         = "team"
+	ObjectTeamSync     = "team_sync"
+	ObjectRepository   = "repository"
+	ObjectPermission   = "permission"
+	ObjectConfig       = "config"
+	ObjectDiscovery    = "discovery"
+)
+
+// Operations of an Action
+const (
+	OpGet    = "get"
+	OpList   = "list"
+	OpCreate = "create"
+	OpUpdate = "update"
+	// OpProbe reads an object to check the access of the token, denials are answers
+	OpProbe = "probe"
+	// OpDetect reads what the host supports, failures fall back to assumed support
+	OpDetect = "detect"
+)
+
+// Outcomes of an Action
+const (
+	OutcomeRead      = "read"
+	OutcomeNotFound  = "not_found"
+	OutcomeCreated   = "created"
+	OutcomeUpdated   = "updated"
+	OutcomeUnchanged = "unchanged"
+	OutcomeFailed    = "failed"
+	OutcomePlanned   = "planned"
+	OutcomeDenied    = "denied"
+	// a failed OpDetect call, the run goes on with the assumed capabilities
+	OutcomeUnavailable = "unavailable"
+)
+
+// Target is the Quay object an api call works on
+type Target struct {
+	Org       string
+	Kind      string
+	Name      string
+	Operation string
+}
+
+func (t Target) String() string {
+	s := t.Operation + " " + t.Kind
+	if t.Name != "" {
+		s += " " + t.Name
+	}
+	if t.Org != "" && (t.Kind != ObjectOrganization || t.Name != t.Org) {
+		s += " org " + t.Org
+	}
+	return s
+}
+
+// Action is a completed api call, passed to Client.OnAction
+type Action struct {
+	Target
+	Host       string
+	Method     string
+	Path       string
+	StatusCode int
+	Attempts   int
+	Duration   time.Duration
+	Outcome    string
+	Err        error
+	// JSON request body of the calls not sent in dry run mode
+	Body string
+}
+
+// IsAlreadyExists reports whether err is Quay refusing to create an object that already exists
+func IsAlreadyExists(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	if apiErr.StatusCode != http.StatusBadRequest && apiErr.StatusCode != http.StatusConflict {
+		return false
+	}
+	body := strings.ToLower(apiErr.Body)
+	return strings.Contains(body, "already exists") || strings.Contains(body, "existing")
+}
+
+// IsNotFound reports whether err is Quay answering that the object does not exist
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// IsDenied reports whether err is Quay refusing the access to an object, or
+// answering not found to hide it
+func IsDenied(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+		return true
+	}
+	return false
+}
+
+// outcome of a call, skipped calls being the dry run ones not sent
+func outcome(t Target, skipped bool, err error) string {
+	switch {
+	case IsAlreadyExists(err):
+		return OutcomeUnchanged
+	case IsNotFound(err) && (t.Operation == OpGet || t.Operation == OpList):
+		return OutcomeNotFound
+	case IsDenied(err) && t.Operation == OpProbe:
+		return OutcomeDenied
+	case err != nil && t.Operation == OpDetect:
+		return OutcomeUnavailable
+	case err != nil:
+		return OutcomeFailed
+	case skipped:
+		return OutcomePlanned
+	case t.Operation == OpCreate:
+		return OutcomeCreated
+	case t.Operation == OpUpdate:
+		return OutcomeUpdated
+	}
+	return OutcomeRead
+}
@@ -0,0 +1,138 @@
+package quay
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"repliquay/repliquay/pkg/apicall"
+	"strconv"
+	"strings"
+)
+
+// Quay features, as named in the features of the /config endpoint
+const (
+	FeatureTeamSync   = "TEAM_SYNCING"
+	FeatureRepoMirror = "REPO_MIRROR"
+	FeatureQuota      = "QUOTA_MANAGEMENT"
+	FeatureAutoPrune  = "AUTO_PRUNE"
+	// without it only superusers can set up team syncing
+	FeatureNonSuperuserTeamSync = "NONSUPERUSER_TEAM_SYNCING_SETUP"
+)
+
+// releaseEndpoints are api endpoints added by a Quay release, newest first.
+// A feature enabled in the configuration of an older release is not supported.
+var releaseEndpoints = []struct {
+	path    string
+	release string
+	feature string
+}{
+	{"/autoprunepolicy/", "3.10", FeatureAutoPrune},
+	{"/quota", "3.7", FeatureQuota},
+}
+
+type publicConfig struct {
+	Features map[string]any `json:"features"`
+}
+
+type discoveryDocument struct {
+	Paths map[string]json.RawMessage `json:"paths"`
+}
+
+// DetectCapabilities reads the features enabled on the host from /config and
+// the api endpoints it provides from /api/v1/discovery. Quay does not expose
+// its release, Version is the oldest release providing the detected endpoints.
+// A non empty release is the declared release of the host: it replaces the
+// endpoint detection, and the features added by newer releases are
+// unsupported even when the capabilities cannot be read.
+func (c *Client) DetectCapabilities(ctx context.Context, release string) (caps apicall.Capabilities, err error) {
+	if release != "" {
+		caps = ReleaseCapabilities(release)
+	}
+	var cfg publicConfig
+	if err = c.call(ctx, Target{Kind: ObjectConfig, Operation: OpDetect}, "GET", "/config", nil, &cfg); err != nil {
+		return
+	}
+	features := make(map[string]bool)
+	for name, enabled := range cfg.Features {
+		if enabled == true && !caps.Unsupported[name] {
+			features[name] = true
+		}
+	}
+	if release != "" {
+		caps.Detected = true
+		caps.Features = features
+		return
+	}
+	var doc discoveryDocument
+	if err = c.call(ctx, Target{Kind: ObjectDiscovery, Operation: OpDetect}, "GET", "/api/v1/discovery", nil, &doc); err != nil {
+		return
+	}
+
+	caps = apicall.Capabilities{Detected: true, Features: features}
+	for _, e := range releaseEndpoints {
+		if !doc.hasPath(e.path) {
+			delete(caps.Features, e.feature)
+			continue
+		}
+		if caps.Version == "" {
+			caps.Version = e.release + "+"
+		}
+	}
+	if caps.Version == "" {
+		caps.Version = "<" + releaseEndpoints[len(releaseEndpoints)-1].release
+	}
+	return
+}
+
+// ReleaseCapabilities returns the capabilities known from the release of a
+// host: the features added by newer releases are unsupported
+func ReleaseCapabilities(release string) apicall.Capabilities {
+	caps := apicall.Capabilities{Version: release, Unsupported: make(map[string]bool)}
+	for _, e := range releaseEndpoints {
+		if CompareReleases(release, e.release) < 0 {
+			caps.Unsupported[e.feature] = true
+		}
+	}
+	return caps
+}
+
+// CheckRelease verifies release is a Quay release number such as 3.7 or 3.12.1
+func CheckRelease(release string) error {
+	for _, p := range strings.Split(release, ".") {
+		if _, err := strconv.Atoi(p); err != nil {
+			return fmt.Errorf("invalid quay release %q, expected numbers separated by dots such as 3.7", release)
+		}
+	}
+	return nil
+}
+
+// CompareReleases compares two release numbers checked by CheckRelease,
+// returning -1, 0 or +1. Missing trailing numbers count as 0.
+func CompareReleases(a, b string) int {
+	pa, pb := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < max(len(pa), len(pb)); i++ {
+		var na, nb int
+		if i < len(pa) {
+			na, _ = strconv.Atoi(pa[i])
+		}
+		if i < len(pb) {
+			nb, _ = strconv.Atoi(pb[i])
+		}
+		if na != nb {
+			if na < nb {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func (d discoveryDocument) hasPath(suffix string) bool {
+	for p := range d.Paths {
+		if strings.HasSuffix(p, suffix) {
+			return true
+		}
+	}
+	return false
+}
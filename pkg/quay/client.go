@@ -0,0 +1,250 @@
+// Package quay is a typed client for the Quay API. Requests are marshalled
+// to JSON and responses decoded into the models in this package, while the
+// underlying apicall.HostConnection takes care of throttling and retries.
+package quay
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"repliquay/repliquay/pkg/apicall"
+	"strings"
+	"time"
+)
+
+const (
+	KindRobot = "robot"
+	KindTeam  = "team"
+)
+
+type Client struct {
+	Conn  *apicall.HostConnection
+	Token string
+	// OnAction, when set, is called after every api call
+	OnAction func(Action)
+}
+
+// APIError is returned when Quay answers with a non 2xx status code
+type APIError struct {
+	Host       string
+	Method     string
+	Path       string
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s %s %s: status code %d: %s", e.Host, e.Method, e.Path, e.StatusCode, strings.TrimSpace(e.Body))
+}
+
+func New(conn *apicall.HostConnection, token string) *Client {
+	return &Client{Conn: conn, Token: token}
+}
+
+// Do sends in (if not nil) as JSON body and decodes the response into out (if not nil).
+// In dry run mode no call is performed and out is left untouched, except for
+// the GET calls of connections with DryRunReads.
+func (c *Client) Do(ctx context.Context, method string, path string, in any, out any, action string) error {
+	return c.call(ctx, Target{Operation: action}, method, path, in, out)
+}
+
+func (c *Client) call(ctx context.Context, target Target, method string, path string, in any, out any) (err error) {
+	start := time.Now()
+	a := Action{Target: target, Host: c.Conn.Hostname, Method: method, Path: path}
+	defer func() {
+		if c.OnAction != nil {
+			a.Duration = time.Since(start)
+			a.Outcome = outcome(target, c.Conn.Skips(method), err)
+			a.Err = err
+			c.OnAction(a)
+		}
+	}()
+
+	var body string
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("%s: unable to encode request for %s: %w", c.Conn.Hostname, target, err)
+		}
+		body = string(data)
+	}
+	if c.Conn.Skips(method) {
+		a.Body = body
+	}
+	httpCode, responseBody, attempts, err := c.Conn.ApiCall(ctx, c.Conn.Hostname, path, method, c.Token, body, target.String())
+	a.StatusCode, a.Attempts = httpCode, attempts
+	if err != nil {
+		return err
+	}
+	if c.Conn.Skips(method) {
+		return nil
+	}
+	if httpCode < 200 || httpCode > 299 {
+		return &APIError{Host: c.Conn.Hostname, Method: method, Path: path, StatusCode: httpCode, Body: responseBody}
+	}
+	if out != nil && responseBody != "" {
+		if err := json.Unmarshal([]byte(responseBody), out); err != nil {
+			return fmt.Errorf("%s: unable to decode response for %s: %w", c.Conn.Hostname, target, err)
+		}
+	}
+	return nil
+}
+
+func escape(s string) string {
+	return url.PathEscape(s)
+}
+
+// RobotFullName returns the org+robot name Quay uses to reference robot accounts
+func RobotFullName(org string, robot string) string {
+	return org + "+" + robot
+}
+
+// RobotShortName strips the org+ prefix from a robot account name
+func RobotShortName(name string) string {
+	if _, short, found := strings.Cut(name, "+"); found {
+		return short
+	}
+	return name
+}
+
+// Users
+
+func (c *Client) GetUser(ctx context.Context) (user User, err error) {
+	err = c.call(ctx, Target{Kind: ObjectUser, Operation: OpGet}, "GET", "/api/v1/user/", nil, &user)
+	return
+}
+
+// ListUsers returns the users of the registry, it requires a superuser token with the super:user scope
+func (c *Client) ListUsers(ctx context.Context) (users []User, err error) {
+	var resp userList
+	err = c.call(ctx, Target{Kind: ObjectUser, Operation: OpList}, "GET", "/api/v1/superuser/users/", nil, &resp)
+	users = resp.Users
+	return
+}
+
+// Organizations
+
+func (c *Client) CreateOrg(ctx context.Context, name string) error {
+	return c.call(ctx, Target{Org: name, Kind: ObjectOrganization, Name: name, Operation: OpCreate}, "POST", "/api/v1/organization/", createOrgRequest{Name: name}, nil)
+}
+
+func (c *Client) GetOrg(ctx context.Context, name string) (org Organization, err error) {
+	err = c.call(ctx, Target{Org: name, Kind: ObjectOrganization, Name: name, Operation: OpGet}, "GET", "/api/v1/organization/"+escape(name), nil, &org)
+	return
+}
+
+// ProbeOrg reads organization name to check the access of the token, Quay
+// answers 403 instead of 404 for missing organizations to non superusers
+func (c *Client) ProbeOrg(ctx context.Context, name string) error {
+	return c.call(ctx, Target{Org: name, Kind: ObjectOrganization, Name: name, Operation: OpProbe}, "GET", "/api/v1/organization/"+escape(name), nil, nil)
+}
+
+// Robots
+
+func (c *Client) ListRobots(ctx context.Context, org string) (robots []Robot, err error) {
+	var resp robotList
+	err = c.call(ctx, Target{Org: org, Kind: ObjectRobot, Operation: OpList}, "GET", "/api/v1/organization/"+escape(org)+"/robots?permissions=true&token=false", nil, &resp)
+	robots = resp.Robots
+	return
+}
+
+func (c *Client) PutRobot(ctx context.Context, org string, name string, description string) error {
+	return c.call(
+		ctx,
+		Target{Org: org, Kind: ObjectRobot, Name: name, Operation: OpCreate},
+		"PUT",
+		"/api/v1/organization/"+escape(org)+"/robots/"+escape(name),
+		robotRequest{Description: description},
+		nil,
+	)
+}
+
+// Teams
+
+func (c *Client) PutTeam(ctx context.Context, org string, name string, team TeamRequest) error {
+	return c.call(ctx, Target{Org: org, Kind: ObjectTeam, Name: name, Operation: OpUpdate}, "PUT", "/api/v1/organization/"+escape(org)+"/team/"+escape(name), team, nil)
+}
+
+func (c *Client) SyncTeam(ctx context.Context, org string, name string, groupDN string) error {
+	return c.call(
+		ctx,
+		Target{Org: org, Kind: ObjectTeamSync, Name: name, Operation: OpUpdate},
+		"POST",
+		"/api/v1/organization/"+escape(org)+"/team/"+escape(name)+"/syncing",
+		teamSyncRequest{GroupDN: groupDN},
+		nil,
+	)
+}
+
+// Repositories
+
+func (c *Client) CreateRepo(ctx context.Context, repo CreateRepoRequest) error {
+	return c.call(ctx, Target{Org: repo.Namespace, Kind: ObjectRepository, Name: repo.Repository, Operation: OpCreate}, "POST", "/api/v1/repository", repo, nil)
+}
+
+// ListRepos returns every repository in namespace following Quay pagination
+func (c *Client) ListRepos(ctx context.Context, namespace string) (repos []Repository, err error) {
+	query := url.Values{}
+	query.Set("public", "true")
+	query.Set("namespace", namespace)
+	for {
+		var resp repositoryList
+		err = c.call(ctx, Target{Org: namespace, Kind: ObjectRepository, Operation: OpList}, "GET", "/api/v1/repository?"+query.Encode(), nil, &resp)
+		if err != nil {
+			return
+		}
+		repos = append(repos, resp.Repositories...)
+		if resp.NextPage == "" {
+			return
+		}
+		query.Set("next_page", resp.NextPage)
+	}
+}
+
+// Permissions
+
+// ListRepoPermissions returns robot/user (KindRobot) or team (KindTeam) permissions of a repository
+func (c *Client) ListRepoPermissions(ctx context.Context, org string, repo string, kind string) (perms []Permission, err error) {
+	var resp permissionList
+	err = c.call(
+		ctx,
+		Target{Org: org, Kind: ObjectPermission, Name: repo + " " + kind, Operation: OpList},
+		"GET",
+		"/api/v1/repository/"+escape(org)+"/"+escape(repo)+"/permissions/"+permissionPath(kind)+"/",
+		nil,
+		&resp,
+	)
+	for _, v := range resp.Permissions {
+		perms = append(perms, v)
+	}
+	return
+}
+
+// SetRepoPermission grants role on org/repo to a robot (short name) or a team
+func (c *Client) SetRepoPermission(ctx context.Context, org string, repo string, kind string, name string, role string) error {
+	target := name
+	if kind == KindRobot {
+		target = RobotFullName(org, name)
+	}
+	return c.call(
+		ctx,
+		Target{Org: org, Kind: ObjectPermission, Name: PermissionName(repo, kind, name, role), Operation: OpUpdate},
+		"PUT",
+		"/api/v1/repository/"+escape(org)+"/"+escape(repo)+"/permissions/"+permissionPath(kind)+"/"+escape(target),
+		roleRequest{Role: role},
+		nil,
+	)
+}
+
+// PermissionName identifies a repository permission in actions and reports
+func PermissionName(repo string, kind string, name string, role string) string {
+	return repo + " " + kind + " " + name + " " + role
+}
+
+func permissionPath(kind string) string {
+	if kind == KindTeam {
+		return "team"
+	}
+	return "user"
+}
@@ -0,0 +1,134 @@
+package quay
+
+// Request bodies
+
+type createOrgRequest struct {
+	Name string `json:"name"`
+}
+
+type robotRequest struct {
+	Description string `json:"description"`
+}
+
+type TeamRequest struct {
+	Role        string `json:"role"`
+	Description string `json:"description,omitempty"`
+}
+
+type teamSyncRequest struct {
+	GroupDN string `json:"group_dn"`
+}
+
+type CreateRepoRequest struct {
+	Namespace   string `json:"namespace"`
+	Repository  string `json:"repository"`
+	Visibility  string `json:"visibility"`
+	Description string `json:"description"`
+}
+
+type roleRequest struct {
+	Role string `json:"role"`
+}
+
+// Responses
+
+type Avatar struct {
+	Name  string `json:"name"`
+	Hash  string `json:"hash"`
+	Color string `json:"color"`
+	Kind  string `json:"kind"`
+}
+
+type User struct {
+	Username      string           `json:"username"`
+	Email         string           `json:"email"`
+	Avatar        Avatar           `json:"avatar"`
+	SuperUser     bool             `json:"super_user"`
+	Organizations []UserOrgSummary `json:"organizations"`
+}
+
+type userList struct {
+	Users []User `json:"users"`
+}
+
+type UserOrgSummary struct {
+	Name               string `json:"name"`
+	Avatar             Avatar `json:"avatar"`
+	CanCreateRepo      bool   `json:"can_create_repo"`
+	Public             bool   `json:"public"`
+	IsOrgAdmin         bool   `json:"is_org_admin"`
+	PreferredNamespace bool   `json:"preferred_namespace"`
+}
+
+type Organization struct {
+	Name                string          `json:"name"`
+	Email               string          `json:"email"`
+	Avatar              Avatar          `json:"avatar"`
+	IsAdmin             bool            `json:"is_admin"`
+	IsMember            bool            `json:"is_member"`
+	Teams               map[string]Team `json:"teams"`
+	OrderedTeams        []string        `json:"ordered_teams"`
+	InvoiceEmail        bool            `json:"invoice_email"`
+	InvoiceEmailAddress string          `json:"invoice_email_address"`
+	TagExpirationS      int             `json:"tag_expiration_s"`
+	IsFreeAccount       bool            `json:"is_free_account"`
+}
+
+type Team struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Role        string `json:"role"`
+	Avatar      Avatar `json:"avatar"`
+	CanView     bool   `json:"can_view"`
+	RepoCount   int    `json:"repo_count"`
+	MemberCount int    `json:"member_count"`
+	IsSynced    bool   `json:"is_synced"`
+}
+
+type Robot struct {
+	// Name is the fully qualified robot name (org+shortname)
+	Name         string      `json:"name"`
+	Created      string      `json:"created"`
+	LastAccessed string      `json:"last_accessed"`
+	Teams        []RobotTeam `json:"teams"`
+	Repositories []string    `json:"repositories"`
+	Description  string      `json:"description"`
+}
+
+type RobotTeam struct {
+	Name   string `json:"name"`
+	Avatar Avatar `json:"avatar"`
+}
+
+type robotList struct {
+	Robots []Robot `json:"robots"`
+}
+
+type Repository struct {
+	Namespace    string  `json:"namespace"`
+	Name         string  `json:"name"`
+	Description  string  `json:"description"`
+	IsPublic     bool    `json:"is_public"`
+	Kind         string  `json:"kind"`
+	State        string  `json:"state"`
+	LastModified int64   `json:"last_modified"`
+	Popularity   float32 `json:"popularity"`
+	IsStarred    bool    `json:"is_starred"`
+}
+
+type repositoryList struct {
+	Repositories []Repository `json:"repositories"`
+	NextPage     string       `json:"next_page"`
+}
+
+type Permission struct {
+	Role        string `json:"role"`
+	Name        string `json:"name"`
+	IsRobot     bool   `json:"is_robot"`
+	Avatar      Avatar `json:"avatar"`
+	IsOrgMember bool   `json:"is_org_member"`
+}
+
+type permissionList struct {
+	Permissions map[string]Permission `json:"permissions"`
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// noProfile, set as repository profile, disables the organization default profile
+const noProfile = "none"
+
+// isProfilesOnly reports whether a YAML document only defines shared permission profiles
+func isProfilesOnly(org Organization) bool {
+	return org.Name == "" && org.OrgRoleName == "" && org.DefaultProfile == "" &&
+		len(org.RepoList) == 0 && len(org.RobotList) == 0 && len(org.TeamsList) == 0 &&
+		len(org.Profiles) > 0
+}
+
+// resolveProfiles adds the shared profiles not redefined by the organization
+// and checks that every referenced profile exists
+func (o *Organization) resolveProfiles(shared map[string]RepoPermissionStruct) error {
+	if o.Profiles == nil {
+		o.Profiles = make(map[string]RepoPermissionStruct)
+	}
+	for name, p := range shared {
+		if _, ok := o.Profiles[name]; !ok {
+			o.Profiles[name] = p
+		}
+	}
+	if _, ok := o.Profiles[noProfile]; ok {
+		return fmt.Errorf("organization %s: permission profile name %q is reserved", o.Name, noProfile)
+	}
+	if o.DefaultProfile != "" && o.DefaultProfile != noProfile {
+		if _, ok := o.Profiles[o.DefaultProfile]; !ok {
+			return fmt.Errorf("organization %s: unknown default_profile %s (available: %v)", o.Name, o.DefaultProfile, slices.Sorted(maps.Keys(o.Profiles)))
+		}
+	}
+	for _, r := range o.RepoList {
+		if r.Profile == "" || r.Profile == noProfile {
+			continue
+		}
+		if _, ok := o.Profiles[r.Profile]; !ok {
+			return fmt.Errorf("organization %s: repository %s uses unknown profile %s (available: %v)", o.Name, r.Name, r.Profile, slices.Sorted(maps.Keys(o.Profiles)))
+		}
+	}
+	return nil
+}
+
+// repoPermissions returns the permissions of a repository: its profile (or
+// the organization default profile), then the matching permission rules,
+// overridden by the repository permissions. Repository permissions with
+// remove: true drop the ones of the profile and rules.
+func (o Organization) repoPermissions(repo RepoStruct) RepoPermissionStruct {
+	base := o.inheritedPermissions(repo)
+	return RepoPermissionStruct{
+		Robots: mergePermissions(base.Robots, repo.PermissionList.Robots),
+		Teams:  mergePermissions(base.Teams, repo.PermissionList.Teams),
+	}
+}
+
+// inheritedPermissions returns the permissions of the profile and the rules of a repository
+func (o Organization) inheritedPermissions(repo RepoStruct) RepoPermissionStruct {
+	profile := repo.Profile
+	if profile == "" {
+		profile = o.DefaultProfile
+	}
+	return o.rulePermissions(repo.Name, o.Profiles[profile], false)
+}
+
+// checkRemovals verifies that the permissions removed from every repository
+// are granted by the repository profile or rules
+func (o Organization) checkRemovals() error {
+	var errs []error
+	for _, r := range o.RepoList {
+		base := o.inheritedPermissions(r)
+		for _, k := range []struct {
+			kind           string
+			base, removals []PermStruct
+		}{
+			{"robot", base.Robots, r.PermissionList.Robots},
+			{"team", base.Teams, r.PermissionList.Teams},
+		} {
+			for _, v := range k.removals {
+				if v.Remove && !slices.ContainsFunc(k.base, func(p PermStruct) bool { return p.Name == v.Name }) {
+					errs = append(errs, fmt.Errorf("organization %s: repository %s removes the permission of %s %s, granted neither by the repository nor by its profile or rules", o.Name, r.Name, k.kind, v.Name))
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// mergePermissions returns base with the roles of overrides replacing the ones
+// of the same robot or team, followed by the overrides not in base. Overrides
+// with remove: true delete the robot or team from base.
+func mergePermissions(base []PermStruct, overrides []PermStruct) (perms []PermStruct) {
+	perms = slices.Clone(base)
+	for _, v := range overrides {
+		i := slices.IndexFunc(perms, func(p PermStruct) bool { return p.Name == v.Name })
+		switch {
+		case v.Remove && i >= 0:
+			perms = slices.Delete(perms, i, i+1)
+		case v.Remove:
+		case i < 0:
+			perms = append(perms, v)
+		default:
+			perms[i].Role = v.Role
+		}
+	}
+	return
+}
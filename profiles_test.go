@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// permNames formats perms as repo:name=role for comparisons
+func permNames(perms []PermStruct) (out []string) {
+	for _, p := range perms {
+		out = append(out, fmt.Sprintf("%s:%s=%s", p.RepoName, p.Name, p.Role))
+	}
+	return
+}
+
+func TestCreatePermissionList(t *testing.T) {
+	profiles := map[string]RepoPermissionStruct{
+		"default": {Robots: []PermStruct{{Name: "ci", Role: "read"}, {Name: "scan", Role: "read"}}},
+		"deploy":  {Robots: []PermStruct{{Name: "deploy", Role: "write"}}},
+	}
+	tests := []struct {
+		name           string
+		defaultProfile string
+		repo           RepoStruct
+		want           []string
+	}{
+		{"no profile", "", RepoStruct{Name: "app"}, nil},
+		{"default profile", "default", RepoStruct{Name: "app"}, []string{"app:ci=read", "app:scan=read"}},
+		{"repository profile over default", "default", RepoStruct{Name: "app", Profile: "deploy"}, []string{"app:deploy=write"}},
+		{"default disabled", "default", RepoStruct{Name: "app", Profile: noProfile}, nil},
+		{"repository role over profile", "default", RepoStruct{Name: "app", PermissionList: RepoPermissionStruct{
+			Robots: []PermStruct{{Name: "ci", Role: "write"}},
+		}}, []string{"app:ci=write", "app:scan=read"}},
+		{"repository permission added", "default", RepoStruct{Name: "app", PermissionList: RepoPermissionStruct{
+			Robots: []PermStruct{{Name: "release", Role: "admin"}},
+		}}, []string{"app:ci=read", "app:scan=read", "app:release=admin"}},
+		{"profile permission removed", "default", RepoStruct{Name: "app", PermissionList: RepoPermissionStruct{
+			Robots: []PermStruct{{Name: "scan", Remove: true}},
+		}}, []string{"app:ci=read"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			org := Organization{Name: "devops", Profiles: profiles, DefaultProfile: tt.defaultProfile, RepoList: []RepoStruct{tt.repo}}
+			if got := permNames(createPermissionList(org, "robots")); !slices.Equal(got, tt.want) {
+				t.Errorf("robot permissions = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveProfiles(t *testing.T) {
+	shared := map[string]RepoPermissionStruct{
+		"default": {Robots: []PermStruct{{Name: "ci", Role: "read"}}},
+		"deploy":  {Robots: []PermStruct{{Name: "deploy", Role: "write"}}},
+	}
+	tests := []struct {
+		name string
+		org  Organization
+		// robot permissions of the default profile once resolved
+		want string
+		err  string
+	}{
+		{"shared profile", Organization{Name: "devops", DefaultProfile: "default"}, "read", ""},
+		{"organization profile over shared", Organization{Name: "devops", DefaultProfile: "default", Profiles: map[string]RepoPermissionStruct{
+			"default": {Robots: []PermStruct{{Name: "ci", Role: "admin"}}},
+		}}, "admin", ""},
+		{"unknown default profile", Organization{Name: "devops", DefaultProfile: "missing"}, "", "organization devops: unknown default_profile missing (available: [default deploy])"},
+		{"unknown repository profile", Organization{Name: "devops", RepoList: []RepoStruct{{Name: "app", Profile: "missing"}}}, "", "organization devops: repository app uses unknown profile missing"},
+		{"default disabled", Organization{Name: "devops", DefaultProfile: noProfile, RepoList: []RepoStruct{{Name: "app", Profile: noProfile}}}, "read", ""},
+		{"reserved name", Organization{Name: "devops", Profiles: map[string]RepoPermissionStruct{noProfile: {}}}, "", `permission profile name "none" is reserved`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.org.resolveProfiles(shared)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.org.Profiles["default"].Robots[0].Role; got != tt.want {
+				t.Errorf("default profile role = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"repliquay/repliquay/pkg/quay"
+	"slices"
+	"strings"
+)
+
+// PermissionRule grants its robots and teams permissions on every repository
+// of the organization whose name matches the glob (repos) or the regular
+// expression (regex). With existing_repos the repositories already on the
+// host and not declared in the organization file are matched as well.
+type PermissionRule struct {
+	Repos    string       `yaml:"repos"`
+	Regex    string       `yaml:"regex"`
+	Existing bool         `yaml:"existing_repos"`
+	Robots   []PermStruct `yaml:"robots"`
+	Teams    []PermStruct `yaml:"teams"`
+	re       *regexp.Regexp
+}
+
+func (r PermissionRule) String() string {
+	if r.Regex != "" {
+		return "regex " + r.Regex
+	}
+	return "repos " + r.Repos
+}
+
+// compile validates the matcher, regular expressions must match the whole repository name
+func (r *PermissionRule) compile() (err error) {
+	switch {
+	case r.Repos != "" && r.Regex != "":
+		return fmt.Errorf("permission rule %s: only one of repos and regex can be defined", r)
+	case r.Regex != "":
+		r.re, err = regexp.Compile("^(?:" + r.Regex + ")$")
+		if err != nil {
+			return fmt.Errorf("permission rule %s: %w", r, err)
+		}
+	case r.Repos != "":
+		if _, err := path.Match(r.Repos, ""); err != nil {
+			return fmt.Errorf("permission rule %s: %w", r, err)
+		}
+	default:
+		return fmt.Errorf("permission rule without repos or regex")
+	}
+	if len(r.Robots) == 0 && len(r.Teams) == 0 {
+		return fmt.Errorf("permission rule %s: no robots or teams", r)
+	}
+	return nil
+}
+
+func (r PermissionRule) matches(repo string) bool {
+	if r.re != nil {
+		return r.re.MatchString(repo)
+	}
+	ok, _ := path.Match(r.Repos, repo)
+	return ok
+}
+
+func (o *Organization) compileRules() error {
+	for i := range o.Rules {
+		if err := o.Rules[i].compile(); err != nil {
+			return fmt.Errorf("organization %s: %w", o.Name, err)
+		}
+	}
+	return nil
+}
+
+// rulePermissions merges the permissions of the rules matching repo over base
+func (o Organization) rulePermissions(repo string, base RepoPermissionStruct, existing bool) RepoPermissionStruct {
+	for _, r := range o.Rules {
+		if (existing && !r.Existing) || !r.matches(repo) {
+			continue
+		}
+		base.Robots = mergePermissions(base.Robots, r.Robots)
+		base.Teams = mergePermissions(base.Teams, r.Teams)
+	}
+	return base
+}
+
+// printRuleMatches prints the declared repositories matched by every rule
+func printRuleMatches(org Organization) {
+	for _, r := range org.Rules {
+		var matched []string
+		for _, repo := range org.RepoList {
+			if r.matches(repo.Name) {
+				matched = append(matched, repo.Name)
+			}
+		}
+		fmt.Printf("Permission rule %s of organization %s matched %d repos: %s\n", r, org.Name, len(matched), strings.Join(matched, ", "))
+	}
+}
+
+// existingRepoPermissions lists the repositories of the organization on the
+// host and returns the permissions of the existing_repos rules matching the
+// ones not declared in the organization file
+func existingRepoPermissions(ctx context.Context, org Organization, client *quay.Client) (permList []PermStruct, err error) {
+	if !slices.ContainsFunc(org.Rules, func(r PermissionRule) bool { return r.Existing }) {
+		return
+	}
+	repos, err := client.ListRepos(ctx, org.Name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the existing repositories matched by the permission rules: %w", err)
+	}
+	matched := make(map[string][]string)
+	for _, repo := range repos {
+		if slices.ContainsFunc(org.RepoList, func(r RepoStruct) bool { return r.Name == repo.Name }) {
+			continue
+		}
+		for _, r := range org.Rules {
+			if r.Existing && r.matches(repo.Name) {
+				matched[r.String()] = append(matched[r.String()], repo.Name)
+			}
+		}
+		perms := org.rulePermissions(repo.Name, RepoPermissionStruct{}, true)
+		for _, v := range perms.Robots {
+			permList = append(permList, PermStruct{Name: v.Name, Role: v.Role, PermissionKind: "robots", RepoName: repo.Name, Organization: org.Name})
+		}
+		for _, v := range perms.Teams {
+			permList = append(permList, PermStruct{Name: v.Name, Role: v.Role, PermissionKind: "teams", RepoName: repo.Name, Organization: org.Name})
+		}
+	}
+	for _, r := range org.Rules {
+		if r.Existing {
+			fmt.Printf("Permission rule %s of organization %s matched %d existing repos - Host: %s: %s\n", r, org.Name, len(matched[r.String()]), client.Conn.Hostname, strings.Join(matched[r.String()], ", "))
+		}
+	}
+	return
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"repliquay/repliquay/pkg/apicall"
+	"repliquay/repliquay/pkg/quay"
+)
+
+func TestCompileRules(t *testing.T) {
+	robots := []PermStruct{{Name: "ci", Role: "read"}}
+	tests := []struct {
+		name string
+		rule PermissionRule
+		err  string
+	}{
+		{"glob", PermissionRule{Repos: "app-*", Robots: robots}, ""},
+		{"regex", PermissionRule{Regex: "app-(web|api)", Robots: robots}, ""},
+		{"bad regex", PermissionRule{Regex: "app-(web", Robots: robots}, "organization devops: permission rule regex app-(web: error parsing regexp"},
+		{"bad glob", PermissionRule{Repos: "app-[", Robots: robots}, "organization devops: permission rule repos app-[: syntax error in pattern"},
+		{"both matchers", PermissionRule{Repos: "app-*", Regex: "app-.*", Robots: robots}, "only one of repos and regex can be defined"},
+		{"no matcher", PermissionRule{Robots: robots}, "permission rule without repos or regex"},
+		{"no permissions", PermissionRule{Repos: "app-*"}, "permission rule repos app-*: no robots or teams"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			org := Organization{Name: "devops", Rules: []PermissionRule{tt.rule}}
+			err := org.compileRules()
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("error = %v, want none", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	tests := []struct {
+		rule PermissionRule
+		repo string
+		want bool
+	}{
+		{PermissionRule{Repos: "app-*"}, "app-web", true},
+		{PermissionRule{Repos: "app-*"}, "lib", false},
+		{PermissionRule{Regex: "app-(web|api)"}, "app-api", true},
+		// regular expressions match the whole name
+		{PermissionRule{Regex: "app"}, "my-app-web", false},
+		{PermissionRule{Regex: "app-.*"}, "app-", true},
+	}
+	for _, tt := range tests {
+		tt.rule.Robots = []PermStruct{{Name: "ci", Role: "read"}}
+		if err := tt.rule.compile(); err != nil {
+			t.Fatal(err)
+		}
+		if got := tt.rule.matches(tt.repo); got != tt.want {
+			t.Errorf("%s matches %s = %t, want %t", tt.rule, tt.repo, got, tt.want)
+		}
+	}
+}
+
+// repoServer returns a client of a server listing repos in every organization
+func repoServer(t *testing.T, repos ...string) *quay.Client {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/repository" {
+			http.NotFound(w, r)
+			return
+		}
+		var list []string
+		for _, name := range repos {
+			list = append(list, `{"name":"`+name+`"}`)
+		}
+		io.WriteString(w, `{"repositories":[`+strings.Join(list, ",")+`]}`)
+	}))
+	t.Cleanup(srv.Close)
+	hc := &apicall.HostConnection{Hostname: strings.TrimPrefix(srv.URL, "http://"), Insecure: true, Output: io.Discard}
+	t.Cleanup(hc.Close)
+	return quay.New(hc, "token")
+}
+
+func TestExistingRepoPermissions(t *testing.T) {
+	org := Organization{
+		Name:     "devops",
+		RepoList: []RepoStruct{{Name: "app-web"}},
+		Rules: []PermissionRule{
+			{Repos: "app-*", Robots: []PermStruct{{Name: "ci", Role: "read"}}},
+			{Repos: "app-*", Existing: true, Teams: []PermStruct{{Name: "devs", Role: "write"}}},
+			{Regex: "legacy-.*", Existing: true, Robots: []PermStruct{{Name: "ci", Role: "admin"}}},
+		},
+	}
+	if err := org.compileRules(); err != nil {
+		t.Fatal(err)
+	}
+
+	// declared repositories get every matching rule
+	if got := permNames(createPermissionList(org, "robots")); !slices.Equal(got, []string{"app-web:ci=read"}) {
+		t.Errorf("declared robot permissions = %q", got)
+	}
+	if got := permNames(createPermissionList(org, "teams")); !slices.Equal(got, []string{"app-web:devs=write"}) {
+		t.Errorf("declared team permissions = %q", got)
+	}
+
+	// existing repositories only get the existing_repos rules, declared ones are skipped
+	perms, err := existingRepoPermissions(context.Background(), org, repoServer(t, "app-web", "app-api", "legacy-db", "lib"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := permNames(perms), []string{"app-api:devs=write", "legacy-db:ci=admin"}; !slices.Equal(got, want) {
+		t.Errorf("existing repository permissions = %q, want %q", got, want)
+	}
+
+	// no existing_repos rule, no listing
+	org.Rules = org.Rules[:1]
+	perms, err = existingRepoPermissions(context.Background(), org, nil)
+	if err != nil || perms != nil {
+		t.Errorf("existingRepoPermissions = %v, %v, want no permissions without existing_repos rules", perms, err)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// isSelector reports whether a target is a label selector rather than a host name
+func isSelector(target string) bool {
+	return strings.Contains(target, "=")
+}
+
+// targetsHost reports whether the organization must be applied to host.
+// Organizations without targets are applied to every host.
+func (o Organization) targetsHost(host HostToken) bool {
+	if len(o.Targets) == 0 {
+		return true
+	}
+	for _, t := range o.Targets {
+		if !isSelector(t) {
+			if t == host.Host {
+				return true
+			}
+			continue
+		}
+		// selectors are validated by checkTargets
+		if selector, err := parseSelector(t); err == nil && matchLabels(selector, host.Labels) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTargets verifies that every host name targeted by an organization is
+// defined in the quays file and that every label selector matches a host
+func checkTargets(orgs []Organization, hosts []HostToken) error {
+	var errs []error
+	for _, o := range orgs {
+		for _, t := range o.Targets {
+			if !isSelector(t) {
+				if !slices.ContainsFunc(hosts, func(h HostToken) bool { return h.Host == t }) {
+					errs = append(errs, fmt.Errorf("organization %s targets host %s not defined in the quays file", o.Name, t))
+				}
+				continue
+			}
+			selector, err := parseSelector(t)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("organization %s: %w", o.Name, err))
+				continue
+			}
+			if !slices.ContainsFunc(hosts, func(h HostToken) bool { return matchLabels(selector, h.Labels) }) {
+				errs = append(errs, fmt.Errorf("organization %s targets %s but no host in the quays file has these labels", o.Name, t))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+var targetHosts = []HostToken{
+	{Host: "quay.example.com", HostSettings: HostSettings{Labels: map[string]string{"env": "prod", "site": "paris"}}},
+	{Host: "dr-quay.example.com", HostSettings: HostSettings{Labels: map[string]string{"env": "dr", "site": "lyon"}}},
+	{Host: "dev-quay.example.com"},
+}
+
+func TestTargetsHost(t *testing.T) {
+	tests := []struct {
+		name    string
+		targets []string
+		want    []bool
+	}{
+		{"no targets", nil, []bool{true, true, true}},
+		{"host name", []string{"dr-quay.example.com"}, []bool{false, true, false}},
+		{"selector", []string{"env=prod"}, []bool{true, false, false}},
+		{"selector with every label", []string{"env=dr,site=paris"}, []bool{false, false, false}},
+		{"name or selector", []string{"dev-quay.example.com", "site=lyon"}, []bool{false, true, true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := Organization{Name: "devops", Targets: tt.targets}
+			for i, h := range targetHosts {
+				if got := o.targetsHost(h); got != tt.want[i] {
+					t.Errorf("targetsHost(%s) = %t, want %t", h.Host, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCheckTargets(t *testing.T) {
+	tests := []struct {
+		name    string
+		targets []string
+		err     string
+	}{
+		{"no targets", nil, ""},
+		{"known host", []string{"quay.example.com"}, ""},
+		{"matching selector", []string{"env=dr"}, ""},
+		{"unknown host", []string{"quay.example.com", "old-quay.example.com"}, "organization devops targets host old-quay.example.com not defined in the quays file"},
+		{"selector without host", []string{"env=test"}, "organization devops targets env=test but no host in the quays file has these labels"},
+		{"invalid selector", []string{"env=prod,dr"}, `organization devops: invalid label selector "env=prod,dr"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkTargets([]Organization{{Name: "devops", Targets: tt.targets}}, targetHosts)
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("error = %v, want none", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}